@@ -4,7 +4,10 @@ import (
 	"io/ioutil"
 	"log"
 	"notifier/consts"
+	eml "notifier/emailNotify"
 	"notifier/parsers"
+	"notifier/registry"
+	slk "notifier/slackNotify"
 	"strings"
 
 	"github.com/urfave/cli"
@@ -14,6 +17,8 @@ import (
 var (
 	//SendConfirm(bool) is to confirm the notif-sending operation(set by boolflag -x or -exe)
 	SendConfirm = false
+	//DryRun(bool) prints every message that would be sent without sending anything(set by boolflag --dry-run)
+	DryRun = false
 )
 
 //global input parameters
@@ -21,11 +26,27 @@ var (
 var (
 	Subject          string
 	Message          string
+	LevelName        string
+	Level            consts.Level
 	MessageFile      string
+	HTMLMessage      string
+	HTMLMessageFile  string
+	BlocksTemplate   string
+	SlackFields      []string
+	SlackContext     []string
+	CodeFile         string
+	Blocks           []slk.Block
+	AttachFiles      []string
+	Attachments      []registry.Attachment
 	ToEmailAddrs     []string
 	ToEmailAddrsFile string
+	CcEmailAddrs     []string
+	BccEmailAddrs    []string
+	ReplyToAddrs     []string
 	ToSlackUsers     []string
 	ToSlackUsersFile string
+	ViaNotifiers     []string
+	NoOutbox         bool
 )
 
 //usage of global input parameters
@@ -33,12 +54,28 @@ var (
 
 const (
 	subjectFlgUsg          = "Specify the title/subject of your notification (UTF-8, maximum 256 bytes for email notification)"
+	levelFlgUsg            = "Specify the severity of your notification: info, warning, error or critical. It sets the slack color and prefixes the email subject, and the notifiers whose minLevel is higher are not used"
 	messageFlgUsg          = "Specify the message of your notification (UTF-8)"
 	msgFileFlgUsg          = "Specify the file that stores your notification message (UTF-8)"
+	htmlFlgUsg             = "Specify the HTML message of your email notification (UTF-8). A plain-text alternative is generated from it if no message is specified"
+	htmlFileFlgUsg         = "Specify the file that stores the HTML message of your email notification (UTF-8)"
+	blocksFlgUsg           = "Specify the YAML/JSON file of the Slack Block Kit blocks of your slack notification, whose strings are templates such as {{.Subject}}"
+	fieldFlgUsg            = "Specify a field (Name=Value) shown in the Block Kit message of your slack notification"
+	contextFlgUsg          = "Specify a context text shown at the bottom of the Block Kit message of your slack notification"
+	codeFlgUsg             = "Specify a file (e.g. error.log) whose end is shown in a code block of the Block Kit message of your slack notification"
+	attachFlgUsg           = "Specify the file(s) to attach (email attachments, slack file uploads with a token)"
 	toEmailAddrsFlgUsg     = "Specify the target email address(es). Do nothing if the email state is off"
-	toSlackUsersFlgUsg     = "Specify the target slack userID(s). Do nothing if the slack state is off"
+	ccEmailAddrsFlgUsg     = "Specify the email address(es) to be carbon copied (Cc header)"
+	bccEmailAddrsFlgUsg    = "Specify the email address(es) to be blind carbon copied (never written into the email headers)"
+	replyToFlgUsg          = "Specify the address(es) the replies to your email notification should be sent to (Reply-To header)"
+	toSlackUsersFlgUsg     = "Specify the target slack userID(s), or @name(s), email(s) and #channel(s) with a slack token. Do nothing if the slack state is off"
 	toEmailAddrsFileFlgUsg = "Specify the file that stores target email address list (one address per line). Do nothing if the email state is off"
 	toSlackUsersFileFlgUsg = "Specify the file that stores target slack userID list (one address per line). Do nothing if the email state is off"
+	dryRunFlgUsg           = "Print every message (SMTP DATA, JSON payloads) that would be sent to which endpoint, without sending anything"
+	noOutboxFlgUsg         = "Do not queue the notifications failed with a temporary error in the outbox ($HOME/" + consts.OutboxDir + ")"
+	configFlgUsg           = "Specify the notifiers config file. Searched for as $XDG_CONFIG_HOME/notifier/notifyrc.yml, $HOME/.notifyrc.yml and ./.notifyrc.yml if not specified"
+	defaultsFlgUsg         = "Specify the default settings file. Searched for as $XDG_CONFIG_HOME/notifier/notifdef.yml, $HOME/.notifdef.yml and ./.notifdef.yml if not specified"
+	viaNotifiersFlgUsg     = "Specify the name(s) of the notifier(s) in .notifyrc to send with (e.g. smtpemailnotifier). All notifiers whose state is on are used if not specified"
 )
 
 func appInit() *cli.App {
@@ -56,14 +93,28 @@ func appInit() *cli.App {
 func appAction(ctx *cli.Context) error {
 
 	//if user didn't specify any arguments
-	if !(ctx.IsSet("execute-send") && SendConfirm) {
+	//a dry-run sends nothing, so it needs no confirmation
+	if !(ctx.IsSet("execute-send") && SendConfirm) && !DryRun {
 		log.Println("\nPlease confirm execution using -x or --exe.\nUse -h or --help for more help.")
 		return nil
 	}
 
+	//parse the level first, nothing is sent with a wrong level
+	var ok bool
+	if Level, ok = consts.ParseLevel(LevelName); !ok {
+		return cli.NewExitError("unknown level \""+LevelName+"\" ("+strings.Join(consts.LevelNames(), ", ")+")", int(consts.MISS_USE))
+	}
+
 	//parse target IDs from flag arguments
 	ToEmailAddrs = ctx.StringSlice("email-addrs")
 	ToSlackUsers = ctx.StringSlice("slack-ids")
+	CcEmailAddrs = ctx.StringSlice("cc")
+	BccEmailAddrs = ctx.StringSlice("bcc")
+	ReplyToAddrs = ctx.StringSlice("reply-to")
+	ViaNotifiers = ctx.StringSlice("via")
+	AttachFiles = ctx.StringSlice("attach")
+	SlackFields = ctx.StringSlice("field")
+	SlackContext = ctx.StringSlice("context")
 	//append those email addrs stored in the file, only if the file is available
 	//and user didn't specify any email addrs
 	if fileBytes, err := ioutil.ReadFile(ToEmailAddrsFile); err == nil && len(ToEmailAddrs) == 0 {
@@ -81,9 +132,24 @@ func appAction(ctx *cli.Context) error {
 	if fileBytes, err := ioutil.ReadFile(MessageFile); err == nil && Message == "" {
 		Message = string(fileBytes)
 	}
+	//get HTML message from the file, only if the file is available
+	//and user didn't specify any HTML message
+	if fileBytes, err := ioutil.ReadFile(HTMLMessageFile); err == nil && HTMLMessage == "" {
+		HTMLMessage = string(fileBytes)
+	}
+	//generate the plain-text message from the HTML message
+	//if user didn't specify any plain-text message
+	if HTMLMessage != "" && Message == "" {
+		Message = eml.HTMLToText(HTMLMessage)
+	}
+	//read the attached files, nothing is sent if any of them is not available
+	var attachErr consts.ERR
+	if Attachments, attachErr = registry.LoadAttachments(AttachFiles); attachErr != consts.NIL {
+		return cli.NewExitError("cannot attach the file(s)", int(attachErr))
+	}
 	//apply the default settings to message, subject, emails or slacks
 	//if any of them is empty
-	dflt, err := parsers.ParseDefaults(consts.DefaultsFile)
+	dflt, err := parsers.ParseDefaults(parsers.DefaultsFile)
 	if err == consts.NIL {
 		//Apply default settings for any empty CLI flags
 		if Message == "" {
@@ -98,10 +164,37 @@ func appAction(ctx *cli.Context) error {
 		if len(ToSlackUsers) == 0 {
 			ToSlackUsers = dflt.GetDfltSlackList()
 		}
+		if len(CcEmailAddrs) == 0 {
+			CcEmailAddrs = dflt.GetDfltCc()
+		}
+		if len(BccEmailAddrs) == 0 {
+			BccEmailAddrs = dflt.GetDfltBcc()
+		}
+		if len(ReplyToAddrs) == 0 {
+			ReplyToAddrs = dflt.GetDfltReplyTo()
+		}
+	}
+
+	//compose the Block Kit message of slack from the template or flags, if any of them is set
+	var blocksErr consts.ERR
+	Blocks, blocksErr = slk.ComposeBlocks(slk.BlockSpec{
+		Template: BlocksTemplate,
+		Subject:  Subject,
+		Message:  Message,
+		Fields:   SlackFields,
+		Context:  SlackContext,
+		CodeFile: CodeFile,
+	})
+	if blocksErr != consts.NIL {
+		return cli.NewExitError("cannot compose the slack blocks", int(blocksErr))
 	}
 
 	//operate all possible notifications
 	//using global variables
+	//a dry-run goes one notifier after another, so that the printed messages are not interleaved
+	if DryRun {
+		return GenNotify()
+	}
 	return MultiRoutineNotify()
 }
 
@@ -112,11 +205,35 @@ func appFlags() []cli.Flag {
 			Usage:       "explicitly confirm to send notifications",
 			Destination: &SendConfirm,
 		},
+		cli.StringFlag{
+			Name:        "config",
+			Usage:       configFlgUsg,
+			EnvVar:      "NOTIFIER_CONFIG",
+			Value:       consts.NotifyrcFile,
+			Destination: &parsers.NotifyrcFile,
+		},
+		cli.StringFlag{
+			Name:        "defaults",
+			Usage:       defaultsFlgUsg,
+			EnvVar:      "NOTIFIER_DEFAULTS",
+			Value:       consts.DefaultsFile,
+			Destination: &parsers.DefaultsFile,
+		},
+		cli.BoolFlag{
+			Name:        "dry-run",
+			Usage:       dryRunFlgUsg,
+			Destination: &DryRun,
+		},
 		cli.StringFlag{
 			Name:        "subject, s",
 			Usage:       subjectFlgUsg,
 			Destination: &Subject,
 		},
+		cli.StringFlag{
+			Name:        "level, l",
+			Usage:       levelFlgUsg,
+			Destination: &LevelName,
+		},
 		cli.StringFlag{
 			Name:        "msg, m",
 			Usage:       messageFlgUsg,
@@ -127,6 +244,38 @@ func appFlags() []cli.Flag {
 			Usage:       msgFileFlgUsg,
 			Destination: &MessageFile,
 		},
+		cli.StringFlag{
+			Name:        "html",
+			Usage:       htmlFlgUsg,
+			Destination: &HTMLMessage,
+		},
+		cli.StringFlag{
+			Name:        "html-file",
+			Usage:       htmlFileFlgUsg,
+			Destination: &HTMLMessageFile,
+		},
+		cli.StringFlag{
+			Name:        "blocks",
+			Usage:       blocksFlgUsg,
+			Destination: &BlocksTemplate,
+		},
+		cli.StringSliceFlag{
+			Name:  "field",
+			Usage: fieldFlgUsg,
+		},
+		cli.StringSliceFlag{
+			Name:  "context",
+			Usage: contextFlgUsg,
+		},
+		cli.StringFlag{
+			Name:        "code",
+			Usage:       codeFlgUsg,
+			Destination: &CodeFile,
+		},
+		cli.StringSliceFlag{
+			Name:  "attach, a",
+			Usage: attachFlgUsg,
+		},
 		cli.StringFlag{
 			Name:        "emails-file, ef",
 			Usage:       toEmailAddrsFileFlgUsg,
@@ -141,10 +290,31 @@ func appFlags() []cli.Flag {
 			Name:  "email-addrs, e",
 			Usage: toEmailAddrsFlgUsg,
 		},
+		cli.StringSliceFlag{
+			Name:  "cc",
+			Usage: ccEmailAddrsFlgUsg,
+		},
+		cli.StringSliceFlag{
+			Name:  "bcc",
+			Usage: bccEmailAddrsFlgUsg,
+		},
+		cli.StringSliceFlag{
+			Name:  "reply-to",
+			Usage: replyToFlgUsg,
+		},
 		cli.StringSliceFlag{
 			Name:  "slack-ids, k",
 			Usage: toSlackUsersFlgUsg,
 		},
+		cli.BoolFlag{
+			Name:        "no-outbox",
+			Usage:       noOutboxFlgUsg,
+			Destination: &NoOutbox,
+		},
+		cli.StringSliceFlag{
+			Name:  "via, n",
+			Usage: viaNotifiersFlgUsg,
+		},
 	}
 }
 
@@ -206,34 +376,188 @@ func appCommands() []cli.Command {
 		},
 		//change notifiers settings(modify config file)
 		{
-			Name:        "setnotif",
-			Aliases:     []string{"notif"},
-			Usage:       "Change(set) notifiers settings, (e.g. slack token, email account)",
-			Subcommands: []cli.Command{},
+			Name:    "setnotif",
+			Aliases: []string{"notif"},
+			Usage:   "Change(set) notifiers settings, (e.g. slack token, email account)",
+			Subcommands: []cli.Command{
+				{
+					Name:  "email",
+					Usage: "Change(set) settings of an smtpemail notifier",
+					Subcommands: []cli.Command{
+						setnotifCommand("host", "Change(set) SMTP host", consts.EmailNotifier, parsers.CfgEmailHost),
+						setnotifCommand("port", "Change(set) SMTP port", consts.EmailNotifier, parsers.CfgEmailPort),
+						setnotifCommand("account", "Change(set) sender email account", consts.EmailNotifier, parsers.CfgEmailAccount),
+						setnotifCommand("pwd", "Change(set) email password, or a reference to it (e.g. env:SMTP_PASS)", consts.EmailNotifier, parsers.CfgEmailPwd),
+					},
+				},
+				{
+					Name:  "slack",
+					Usage: "Change(set) settings of a slack or slackWebhook notifier",
+					Subcommands: []cli.Command{
+						setnotifCommand("token", "Change(set) slack token, or a reference to it (e.g. file:/run/secrets/slack)", strings.ToLower(consts.SlackNotifier), parsers.CfgSlackToken),
+						setnotifCommand("add-webhook", "Add a slack incoming webhook url", strings.ToLower(consts.SlackNotifier),
+							func(ntfName, webhookURL string) error {
+								return parsers.CfgSlackWebhooks(ntfName, webhookURL, false)
+							}),
+						setnotifCommand("remove-webhook", "Remove a slack incoming webhook url", strings.ToLower(consts.SlackNotifier),
+							func(ntfName, webhookURL string) error {
+								return parsers.CfgSlackWebhooks(ntfName, webhookURL, true)
+							}),
+					},
+				},
+				{
+					Name:  "webhook",
+					Usage: "Change(set) settings of a webhook notifier",
+					Subcommands: []cli.Command{
+						setnotifCommand("url", "Change(set) webhook url", consts.WebhookNotifier, parsers.CfgWebhookURL),
+					},
+				},
+			},
+		},
+		//list the slack channels and users visible to a token
+		{
+			Name:  "slack",
+			Usage: "List the slack channels and users visible to the token of a slack notifier (with some subcommands)",
+			Subcommands: []cli.Command{
+				slackListCommand("channels", "List the public and private channels (not archived) with their IDs", false),
+				slackListCommand("users", "List the users (not deleted) with their IDs, names and emails", true),
+			},
+		},
+		//check the config files
+		{
+			Name:  "config",
+			Usage: "Check the config files (with some subcommands)",
+			Subcommands: []cli.Command{
+				{
+					Name:  "validate",
+					Usage: "Check the notifiers and default settings files and print the problems found with their line numbers",
+					Action: func(c *cli.Context) error {
+						return ValidateConfig()
+					},
+				},
+			},
+		},
+		//send the notifications queued in the outbox again
+		{
+			Name:  "flush",
+			Usage: "Send the notifications failed with a temporary error again (queued in $HOME/" + consts.OutboxDir + ")",
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:  "max-age",
+					Usage: "drop the queued notifications older than this (e.g. 2h, 30m)",
+					Value: consts.OutboxMaxAge,
+				},
+			},
+			Action: func(ctx *cli.Context) error {
+				return FlushOutbox(ctx.Duration("max-age"))
+			},
 		},
 		{
-			Name:    "toggle",
-			Aliases: []string{"tog"},
-			Usage:   "toggle notifier state between 'on' and 'off' ",
+			Name:      "toggle",
+			Aliases:   []string{"tog"},
+			Usage:     "toggle notifier state between 'on' and 'off' ",
+			ArgsUsage: "[notifier names in .notifyrc...]",
 			Flags: []cli.Flag{
 				cli.BoolFlag{
 					Name:  "email",
-					Usage: "toggle email notifier state",
+					Usage: "toggle email notifier state (same as the name " + consts.EmailNotifier + ")",
 				},
 				cli.BoolFlag{
 					Name:  "slack",
-					Usage: "toggle slack notifier state",
+					Usage: "toggle slack notifier state (same as the name " + strings.ToLower(consts.SlackNotifier) + ")",
 				},
 			},
 			Action: func(ctx *cli.Context) error {
+				ntfNames := ctx.Args()
 				if ctx.Bool("email") {
-					parsers.CfgToggStat(consts.EmailNotifier)
+					ntfNames = append(ntfNames, consts.EmailNotifier)
 				}
 				if ctx.Bool("slack") {
-					parsers.CfgToggStat(consts.SlackNotifier)
+					ntfNames = append(ntfNames, consts.SlackNotifier)
+				}
+				for _, ntfName := range ntfNames {
+					if err := parsers.CfgToggStat(ntfName); err != consts.NIL {
+						return cli.NewExitError("", int(err))
+					}
 				}
 				return nil
 			},
 		},
 	}
 }
+
+//slackListCommand builds a subcommand of slack which lists the channels or users (users is true)
+//e.g. notifier slack users --filter alice --save, or notifier slack channels --save C0123456789
+func slackListCommand(name, usage string, users bool) cli.Command {
+	return cli.Command{
+		Name:      name,
+		Usage:     usage,
+		ArgsUsage: "[ID...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "name, n",
+				Usage: "name of the slack notifier in .notifyrc whose token is used",
+				Value: strings.ToLower(consts.SlackNotifier),
+			},
+			cli.StringFlag{
+				Name:  "filter, f",
+				Usage: "only list those whose ID, name or email contains `TEXT` (case-insensitive)",
+			},
+			cli.IntFlag{
+				Name:  "limit",
+				Usage: "list at most `N` of them, 0 for all",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "print a JSON array instead of a table",
+			},
+			cli.BoolFlag{
+				Name:  "save",
+				Usage: "add the IDs listed to the default slackListFile (see setdefault slackListFile), only with --filter or IDs",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			if ctx.Int("limit") < 0 {
+				return cli.NewExitError("--limit cannot be negative, see --help", int(consts.MISS_USE))
+			}
+			//saving the whole workspace would notify everyone with the next plain "notifier"
+			if ctx.Bool("save") && ctx.String("filter") == "" && ctx.NArg() == 0 {
+				return cli.NewExitError("--save needs --filter or the IDs to save, see --help", int(consts.MISS_USE))
+			}
+			return SlackList(users, SlackListOptions{
+				Notifier: ctx.String("name"),
+				IDs:      ctx.Args(),
+				Filter:   ctx.String("filter"),
+				Limit:    ctx.Int("limit"),
+				JSON:     ctx.Bool("json"),
+				Save:     ctx.Bool("save"),
+			})
+		},
+	}
+}
+
+//setnotifCommand builds a subcommand of setnotif which sets a value of the notifier named by --name
+//e.g. notifier setnotif email host --name gmail-fallback smtp.gmail.com
+func setnotifCommand(name, usage, dfltNtfName string, set func(ntfName, val string) error) cli.Command {
+	return cli.Command{
+		Name:      name,
+		Usage:     usage,
+		ArgsUsage: "VALUE",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "name, n",
+				Usage: "name of the notifier in .notifyrc",
+				Value: dfltNtfName,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			if ctx.NArg() != 1 {
+				return cli.NewExitError("exactly one value is needed, see --help", int(consts.MISS_USE))
+			}
+			if err := set(strings.ToLower(ctx.String("name")), ctx.Args().First()); err != nil {
+				return cli.NewExitError(err.Error(), int(consts.MISS_USE))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"notifier/consts"
+	"notifier/parsers"
+	"notifier/registry"
+
+	"github.com/urfave/cli"
+)
+
+//ValidateConfig checks the notifiers config file and the default settings file
+//and prints every problem found with its line number
+//exits with NOTIFRC_PARSE_ERR or DFLTS_PARSE_ERR if any error is found
+func ValidateConfig() error {
+	ntfDiags := parsers.ValidateNotifiers(parsers.NotifyrcFile, registry.Types())
+	dfltDiags := parsers.ValidateDefaults(parsers.DefaultsFile)
+
+	errs, warns := 0, 0
+	for _, d := range append(ntfDiags, dfltDiags...) {
+		fmt.Println(d)
+		if d.Warning {
+			warns++
+		} else {
+			errs++
+		}
+	}
+	fmt.Printf("%d error(s), %d warning(s)\n", errs, warns)
+
+	switch {
+	case parsers.HasErrors(ntfDiags):
+		return cli.NewExitError("", int(consts.NOTIFRC_PARSE_ERR))
+	case parsers.HasErrors(dfltDiags):
+		return cli.NewExitError("", int(consts.DFLTS_PARSE_ERR))
+	}
+	return nil
+}
@@ -1,5 +1,12 @@
 package consts
 
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
 //app properties consts
 const (
 	AppName     = "Notifier"
@@ -11,25 +18,164 @@ const (
 
 //limitation parameters
 const (
-	//maximum subject length for email(Bytes)
+	//maximum subject length for email(Bytes, before RFC 2047 encoding)
 	MAX_EMAIL_SUBJECT_LEN = 256
+	//maximum size of one attachment(Bytes)
+	MAX_ATTACH_SIZE = 10 << 20
+	//maximum total size of the attachments of a notification(Bytes)
+	//a little less than the usual 25MB limit of mail servers, since base64 grows the data by a third
+	MAX_ATTACH_TOTAL_SIZE = 18 << 20
+	//maximum length of the text of a slack header block(characters)
+	MAX_SLACK_HEADER_LEN = 150
+	//maximum length of the text of a slack section block or field(characters)
+	MAX_SLACK_TEXT_LEN  = 3000
+	MAX_SLACK_FIELD_LEN = 2000
+	//maximum number of fields in a slack section block, and of blocks in a slack message
+	MAX_SLACK_FIELDS = 10
+	MAX_SLACK_BLOCKS = 50
 )
 
 //config files
 const (
 	NotifyrcFile string = ".notifyrc"
 	DefaultsFile string = ".notifdef"
+	//extension of the backup of a config file, which keeps the previous version when the file is modified by a command
+	BackupExt string = ".bak"
+	//prefix of the environment variables overriding the keys of the config files, see parsers.EnvName
+	EnvPrefix string = "NOTIFIER"
+)
+
+//outbox for the notifications failed with a temporary error(relative to $HOME)
+const (
+	OutboxDir string = ".notifier/outbox"
+	//default maximum age of an outbox entry, older entries are dropped when flushing
+	OutboxMaxAge = 24 * time.Hour
+)
+
+//timeout of the connection to an SMTP server, and of each read or write of the SMTP session after it
+const SMTPTimeout = 30 * time.Second
+
+//cache of the slack IDs resolved from names, emails and #channels(relative to $HOME)
+const (
+	SlackCacheFile string = ".notifier/slack-ids.json"
+	//maximum age of a cached slack ID, older IDs are looked up again
+	SlackCacheMaxAge = 7 * 24 * time.Hour
 )
 
 //Notifiers name
 const (
-	EmailNotifier string = "smtpemailnotifier"
-	SlackNotifier string = "slackNotifier"
+	EmailNotifier   string = "smtpemailnotifier"
+	SlackNotifier   string = "slackNotifier"
+	WebhookNotifier string = "webhooknotifier"
+)
+
+//Notifier types (the "type" key of each notifier in notifyrcFile)
+const (
+	SMTPEmailType    string = "smtpemail"
+	SlackType        string = "slack"
+	SlackWebhookType string = "slackWebhook"
+	WebhookType      string = "webhook"
+)
+
+//TLS modes of the smtpemail notifier (the "tlsMode" key in notifyrcFile)
+const (
+	TLSImplicit string = "implicit" //TLS from the beginning of the connection, usually port 465 (default)
+	TLSStartTLS string = "starttls" //plain connection upgraded with STARTTLS, usually port 587
+	TLSNone     string = "none"     //plain connection without TLS, e.g. a test relay on port 25
+)
+
+//SMTP authentication mechanisms of the smtpemail notifier (the "auth" key in notifyrcFile)
+const (
+	AuthPlain   string = "plain"
+	AuthLogin   string = "login"
+	AuthCRAMMD5 string = "cram-md5"
+	AuthXOAUTH2 string = "xoauth2" //OAuth2 access token read from tokenFile or the output of tokenCmd
+	AuthNone    string = "none"    //no authentication, e.g. an internal relay
 )
 
+//Recipient kinds, used to pick the target IDs of a notification for each notifier type
+const (
+	EmailRecipients    string = "email"
+	EmailCcRecipients  string = "email-cc"
+	EmailBccRecipients string = "email-bcc" //only in the SMTP envelope, never in the email headers
+	SlackRecipients    string = "slack"
+)
+
+//Level is the severity of a notification (the --level flag), and the minimum level of a notifier (the "minLevel" key in notifyrcFile)
+//LevelNone means no level was given, the notification is then formatted as usual and routed as LevelInfo
+type Level uint8
+
+//Severity levels, in increasing order
+const (
+	LevelNone Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+	LevelCritical
+)
+
+//levelNames are the names of the levels, as in the --level flag
+var levelNames = []string{"", "info", "warning", "error", "critical"}
+
+//LevelNames returns the names of the levels in increasing order, e.g. for usages and errors
+func LevelNames() []string {
+	return levelNames[LevelInfo:]
+}
+
+//ParseLevel returns the level of a name (case-insensitive), "" is LevelNone
+//ok is false if name is not a level
+func ParseLevel(name string) (level Level, ok bool) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), true
+		}
+	}
+	return LevelNone, false
+}
+
+//String returns the name of the level
+func (l Level) String() string {
+	if int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+//Routing returns the level a notification is routed with, which is LevelInfo if no level was given
+func (l Level) Routing() Level {
+	if l == LevelNone {
+		return LevelInfo
+	}
+	return l
+}
+
+//MarshalText and UnmarshalText store a level by its name (e.g. in the outbox)
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Level) UnmarshalText(text []byte) error {
+	level, ok := ParseLevel(string(text))
+	if !ok {
+		return errors.New("unknown level \"" + string(text) + "\"")
+	}
+	*l = level
+	return nil
+}
+
 //ERR refers to error code(0~255), equals to uint8
 type ERR uint8
 
+//Retryable reports whether the ERR is temporary(T) rather than permanent(P)
+//a delivery failed with a temporary ERR can be sent again later with the same settings
+func (err ERR) Retryable() bool {
+	switch err {
+	case SMTPM_SVR_CONN_ERR, SLK_SVR_CONN_ERR, REQ_FAIL, RATE_LIMITED, ROLLUP_ERROR:
+		return true
+	}
+	return false
+}
+
 //common error codes
 const (
 	ERR_MAX = 255
@@ -39,6 +185,7 @@ const (
 	SUCCESS             ERR = 0
 	GENERAL_ERR         ERR = 1
 	MISS_USE            ERR = 2
+	PARTIAL_FAIL        ERR = 3 //some notifications were delivered while others failed, see the delivery report
 	CMD_CANNOT_EXE      ERR = 126
 	CMD_NOT_FOUND       ERR = 127
 	INVALID_ARG_TO_EXIT ERR = 128
@@ -47,11 +194,14 @@ const (
 	//file-reading error code
 	NOTIFRC_PARSE_ERR ERR = 55 //eror occurs while parsing notifier config file(P)
 	DFLTS_PARSE_ERR   ERR = 56 //error occurs while pasing default config file(P)
+	NTF_NOT_FOUND     ERR = 57 //no notifier with the specified name in notifier config file(P)
+	ATTACH_ERR        ERR = 58 //error occurs while reading an attachment, or the attachments are too large(P)
+	BLOCKS_ERR        ERR = 59 //error occurs while composing the slack blocks from a template file or flags(P)
 
 	//smtpemail error code
 	SMTPM_NOTGT         ERR = 10 //no target email address
 	SMTPM_INVAL         ERR = 11 //smtp notif not valid(Not an exact error)
-	SMTPM_SVR_CONN_ERR  ERR = 12 //lose internet connection or get refused by remote host.stop and check network, host and port(P)
+	SMTPM_SVR_CONN_ERR  ERR = 12 //lose internet connection or get refused by remote host.check network, host and port(T)
 	SMTPM_CLT_BLD_ERR   ERR = 13 //error occurs while building a client, stop and check network, host and port(P)
 	SMTPM_AUTH_ERR      ERR = 14 //error occurs while authenticating mail account and password, stop and check your account and network(P)
 	SMTPM_SENDER_ERR    ERR = 15 //error occurs while adding sender account, stop and check your account(P)
@@ -59,6 +209,7 @@ const (
 	SMTPM_CLT_IO_ERR    ERR = 17 //... while initializing or close a iowriter for email client(P)
 	SMTPM_CLT_DATA_ERR  ERR = 18 //... while trying to write message in the client(P)
 	SMTPM_CLT_CLOSE_ERR ERR = 19 //... while closing an smtpemail client(P)
+	SMTPM_TLS_ERR       ERR = 20 //STARTTLS is not advertised by the server, or fails, or the TLS setting is invalid. check the host, port and TLS setting(P)
 
 	//slack error code
 	SLK_NOTGT        ERR = 28 //no target slack ids
@@ -66,15 +217,23 @@ const (
 	SLK_TOKEN_INVAL  ERR = 30 //slack token not invalid(P)
 	SLK_CHL_ERR      ERR = 31 //token is right, just got stuck in posting to one target user(or channel)(P)
 	SLK_SVR_CONN_ERR ERR = 32 //got stuck because of the network, or be refused by slack host.(T)
+	SLK_RCPT_ERR     ERR = 33 //a slack recipient (@name, email or #channel) is unknown or ambiguous, check it or use its ID(P)
 
 	//slack webhook error code
-	REQ_FAIL        ERR = 39 //no network connection
+	REQ_FAIL        ERR = 39 //no network connection(T)
 	INVALID_PAYLOAD ERR = 40 /*HTTP 400 Bad Request the data sent in your request cannot be understood as presented.
-	  verify your content body matches your content type and is structurally valid.*/
-	USER_NOT_FOUND ERR = 42 //HTTP 400 bad Request. the user used in your request does not actually exist.
-	ACTION_FORBID  ERR = 43 //HTTP 403 Forbidden. the team associated with your request has some kind of restriction on the webhook posting in this context.
-	CHL_NOT_FOUND  ERR = 44 //HTTP 404 Not Found. the channel associated with your request does not exist.
-	CHL_ARCHIVED   ERR = 41 //HTTP 410 Gone. the channel has been archived and doesn't accept further messages, even from your incoming webhook.
-	ROLLUP_ERROR   ERR = 50 //HTTP 500 Server Error. something strange and unusual happened that was likely not your fault at all.
+	  verify your content body matches your content type and is structurally valid.(P)*/
+	USER_NOT_FOUND ERR = 42 //HTTP 400 bad Request. the user used in your request does not actually exist.(P)
+	ACTION_FORBID  ERR = 43 //HTTP 403 Forbidden. the team associated with your request has some kind of restriction on the webhook posting in this context.(P)
+	CHL_NOT_FOUND  ERR = 44 //HTTP 404 Not Found. the channel associated with your request does not exist.(P)
+	CHL_ARCHIVED   ERR = 41 //HTTP 410 Gone. the channel has been archived and doesn't accept further messages, even from your incoming webhook.(P)
+	RATE_LIMITED   ERR = 45 //HTTP 429 Too Many Requests. the posting is rate limited, wait for the Retry-After seconds(T)
+	ROLLUP_ERROR   ERR = 50 //HTTP 5xx Server Error (500, 502, 503, 504...). something strange and unusual happened that was likely not your fault at all.(T)
+
+	//generic webhook error code
+	//the HTTP 400/403/404/410/5xx responses of a generic webhook are mapped onto the slack webhook error codes above
+	WHK_INVAL    ERR = 60 //webhook notif not valid(Not an exact error)
+	WHK_TMPL_ERR ERR = 61 //error occurs while rendering the body template of a webhook, check the template(P)
+	WHK_HTTP_ERR ERR = 62 //the webhook (or slack webhook) responded with an unexpected HTTP status code(P)
 
 )
@@ -0,0 +1,144 @@
+package emailNotify
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"notifier/consts"
+	"notifier/parsers"
+	"os/exec"
+	"strings"
+)
+
+//AuthMech returns the SMTP authentication mechanism of an smtpemail notifier in lower case
+//consts.AuthPlain if it is not set, or consts.AuthNone if no password is set either
+func AuthMech(ntf parsers.SmtpEmailNotifier) string {
+	if ntf.Auth != "" {
+		return strings.ToLower(ntf.Auth)
+	}
+	if ntf.Pwd == "" {
+		return consts.AuthNone
+	}
+	return consts.AuthPlain
+}
+
+//newAuth builds the smtp.Auth of the authentication mechanism of an smtpemail notifier
+//returns nil for consts.AuthNone
+//nothing is read or run here: the OAuth2 token of xoauth2 is obtained when authenticating (never in dry-run mode)
+func newAuth(ntf parsers.SmtpEmailNotifier) (smtp.Auth, error) {
+	username := envelopeAddress(ntf.Account)
+	switch AuthMech(ntf) {
+	case consts.AuthPlain:
+		return smtp.PlainAuth("", username, ntf.Pwd, ntf.SMTPHost), nil
+	case consts.AuthLogin:
+		return &loginAuth{username: username, password: ntf.Pwd}, nil
+	case consts.AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(username, ntf.Pwd), nil
+	case consts.AuthXOAUTH2:
+		if ntf.TokenFile == "" && ntf.TokenCmd == "" {
+			return nil, errors.New("tokenFile or tokenCmd is needed for auth xoauth2")
+		}
+		return &xoauth2Auth{username: username, ntf: ntf}, nil
+	case consts.AuthNone:
+		return nil, nil
+	}
+	return nil, errors.New("unknown auth \"" + ntf.Auth + "\" (plain, login, cram-md5, xoauth2 or none)")
+}
+
+//oauth2Token reads the OAuth2 access token from tokenFile, or runs tokenCmd and reads its output
+//e.g. tokenCmd: "gcloud auth print-access-token"
+func oauth2Token(ntf parsers.SmtpEmailNotifier) (string, error) {
+	var token []byte
+	var err error
+	switch {
+	case ntf.TokenFile != "":
+		token, err = ioutil.ReadFile(ntf.TokenFile)
+	case ntf.TokenCmd != "":
+		token, err = exec.Command("sh", "-c", ntf.TokenCmd).Output()
+	default:
+		return "", errors.New("tokenFile or tokenCmd is needed for auth xoauth2")
+	}
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(token)) == "" {
+		return "", errors.New("empty OAuth2 token for auth xoauth2")
+	}
+	parsers.AddSecret(strings.TrimSpace(string(token)))
+	return strings.TrimSpace(string(token)), nil
+}
+
+//checkTLS refuses to send credentials over an unencrypted connection, except to localhost
+//the same check as smtp.PlainAuth
+func checkTLS(server *smtp.ServerInfo) error {
+	if server.TLS {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(server.Name)
+	if err != nil {
+		host = server.Name
+	}
+	if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+		return nil
+	}
+	return errors.New("unencrypted connection")
+}
+
+//loginAuth implements the LOGIN authentication mechanism
+//the server asks for the username and then the password
+type loginAuth struct {
+	username string
+	password string
+}
+
+//Start begins the LOGIN authentication, over TLS only
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := checkTLS(server); err != nil {
+		return "", nil, err
+	}
+	return "LOGIN", nil, nil
+}
+
+//Next answers the username and password challenges of the server
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(string(fromServer))
+	switch {
+	case strings.Contains(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.Contains(prompt, "password"):
+		return []byte(a.password), nil
+	}
+	return nil, errors.New("unexpected LOGIN challenge from server: " + string(fromServer))
+}
+
+//xoauth2Auth implements the XOAUTH2 authentication mechanism (Gmail, Office365)
+//the access token is read from tokenFile or tokenCmd of ntf when authenticating, see oauth2Token
+type xoauth2Auth struct {
+	username string
+	ntf      parsers.SmtpEmailNotifier
+}
+
+//Start sends the username and the access token, over TLS only
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := checkTLS(server); err != nil {
+		return "", nil, err
+	}
+	token, err := oauth2Token(a.ntf)
+	if err != nil {
+		return "", nil, err
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+//Next acknowledges the error sent by the server if the token is refused
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		//the server sends an error in JSON and expects an empty response before failing
+		return []byte{}, nil
+	}
+	return nil, nil
+}
@@ -2,21 +2,58 @@ package emailNotify
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/smtp"
 	"notifier/consts"
 	"notifier/parsers"
+	"notifier/registry"
 	"strings"
+	"sync"
+	"time"
 )
 
 //Mail is the struct corresponding to a complete email content
 //including the addr of sender, receivers, mail subject(title) and mail body
 type Mail struct {
-	senderID string
-	toIds    []string
-	subject  string
-	body     string
+	senderID    string
+	addrs       Addresses
+	rcptIds     []string //receivers in the SMTP envelope, see Addresses.Rcpts
+	subject     string
+	body        string
+	htmlBody    string //sent as an alternative to body if not empty
+	attachments []registry.Attachment
+}
+
+//Addresses are the receivers of an email and its Reply-To addresses
+type Addresses struct {
+	To      []string
+	Cc      []string
+	Bcc     []string //only added to the SMTP envelope, never written into the headers
+	ReplyTo []string
+}
+
+//Rcpts returns all the receivers (To, Cc and Bcc) without duplicates
+//which are added to the SMTP envelope with RCPT TO
+func (addrs Addresses) Rcpts() []string {
+	var rcpts []string
+	seen := make(map[string]bool)
+	for _, list := range [][]string{addrs.To, addrs.Cc, addrs.Bcc} {
+		for _, addr := range list {
+			key := strings.ToLower(envelopeAddress(addr))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			rcpts = append(rcpts, addr)
+		}
+	}
+	return rcpts
 }
 
 //SmtpServer is the struct corresponding to a SMTP server setting
@@ -24,6 +61,7 @@ type Mail struct {
 type SmtpServer struct {
 	host      string
 	port      string
+	tlsMode   string //consts.TLSImplicit, consts.TLSStartTLS or consts.TLSNone
 	tlsconfig *tls.Config
 }
 
@@ -34,143 +72,318 @@ func (s *SmtpServer) ServerName() string {
 }
 
 //BuildMessage constructs a standard mail message from mail subject(title), mail body, sender and receivers
-//Email has a specific form so we need to build it
+//Email has a specific form so we need to build it:
+//MIME headers with RFC 2047 encoded subject, and a UTF-8 body in quoted-printable or base64
+//Bcc receivers are never written into the headers
+//the body is a multipart/alternative of plain text and HTML if the mail has an HTML body
+//and a multipart/mixed of the body and the attached files if the mail has attachments
 func (mail *Mail) BuildMessage() string {
-	message := bytes.NewBufferString("From: ")
-	message.WriteString(mail.senderID)
-	message.WriteString("\r\n")
-	message.WriteString("To: ")
-	if len(mail.toIds) > 0 {
-		message.WriteString(strings.Join(mail.toIds, ";"))
-		message.WriteString("\r\n")
+	var hdr header
+	hdr.add("From", formatAddress(mail.senderID))
+	if len(mail.addrs.To) > 0 {
+		hdr.add("To", formatAddressList(mail.addrs.To))
+	} else if len(mail.addrs.Cc) == 0 {
+		//only Bcc receivers, which must not be disclosed
+		hdr.add("To", "undisclosed-recipients:;")
+	}
+	if len(mail.addrs.Cc) > 0 {
+		hdr.add("Cc", formatAddressList(mail.addrs.Cc))
+	}
+	if len(mail.addrs.ReplyTo) > 0 {
+		hdr.add("Reply-To", formatAddressList(mail.addrs.ReplyTo))
 	}
-	message.WriteString("Subject: ")
-	message.WriteString(mail.subject)
-	message.WriteString("\r\n\r\n")
-	message.WriteString(mail.body)
+	hdr.add("Subject", encodeHeader("Subject", mail.subject))
+	hdr.add("Date", time.Now().Format(time.RFC1123Z))
+	hdr.add("Message-ID", newMessageID(mail.senderID))
+	hdr.add("MIME-Version", "1.0")
+
+	content := textEntity("text/plain", mail.body)
+	if mail.htmlBody != "" {
+		//the preferred part comes last
+		content = multipartEntity("alternative", []mimeEntity{content, textEntity("text/html", mail.htmlBody)})
+	}
+	if len(mail.attachments) > 0 {
+		parts := []mimeEntity{content}
+		for _, att := range mail.attachments {
+			parts = append(parts, attachmentEntity(att))
+		}
+		content = multipartEntity("mixed", parts)
+	}
+
+	message := new(bytes.Buffer)
+	hdr.writeTo(message)
+	content.header.writeTo(message)
+	message.WriteString("\r\n")
+	message.Write(content.body)
 
 	return message.String()
 }
 
-//newMail initializes a mail struct
-//trims the subject if it is longer than MAX_EMAIL_SUBJECT_LEN
+//newMail initializes a mail struct to be sent to rcpts (some or all of addrs.Rcpts())
+//trims the subject if it is longer than MAX_EMAIL_SUBJECT_LEN bytes, without splitting a multi-byte character
 //then returns the mail struct
-func newMail(from string, to []string, subject string, body string) *Mail {
-	if len(subject) > consts.MAX_EMAIL_SUBJECT_LEN {
-		subject = subject[0:255]
-	}
+func newMail(from string, addrs Addresses, rcpts []string, subject string, body string, htmlBody string, attachments []registry.Attachment) *Mail {
+	subject = truncateUTF8(subject, consts.MAX_EMAIL_SUBJECT_LEN)
 	mail := new(Mail)
 	mail.senderID = from
-	mail.toIds = to
+	mail.addrs = addrs
+	mail.rcptIds = rcpts
 	mail.subject = subject
 	mail.body = body
+	mail.htmlBody = htmlBody
+	mail.attachments = attachments
 
 	return mail
 }
 
-//newSMTPServer initializes a SmtpServer struct from given host and port
+//newSMTPServer initializes a SmtpServer struct from given host, port and TLS setting
 //then returns the struct
-func newSMTPServer(host string, port string) *SmtpServer {
+func newSMTPServer(host string, port string, tlsMode string, tlsconfig *tls.Config) *SmtpServer {
 	smtpServer := new(SmtpServer)
 	smtpServer.host = host
 	smtpServer.port = port
-	smtpServer.tlsconfig = &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         smtpServer.host,
-	}
+	smtpServer.tlsMode = tlsMode
+	smtpServer.tlsconfig = tlsconfig
 
 	return smtpServer
 }
 
 //smtpEmail sends email using SMTP protocol with a specific SMTP server and account
 //the core function of email-notifier
-func smtpEmail(mail *Mail, smtpServer *SmtpServer, pwd string) consts.ERR {
+//delivery continues for all receivers even if some of them are refused by the server
+func smtpEmail(ctx context.Context, mail *Mail, smtpServer *SmtpServer, auth smtp.Auth) []registry.Delivery {
 	msgBody := mail.BuildMessage()
-	log.Println("connecting smtpserver", smtpServer.ServerName())
-
-	//build an authentication
-	auth := smtp.PlainAuth("", mail.senderID, pwd, smtpServer.host)
-
-	conn, err := tls.Dial("tcp", smtpServer.ServerName(), smtpServer.tlsconfig)
-	if err != nil { //no such host
-		log.Println(err)
-		return consts.SMTPM_SVR_CONN_ERR
+	if w := registry.DryRun(ctx); w != nil {
+		printDryRun(w, mail, smtpServer, msgBody)
+		return registry.DryRunDeliveries(mail.rcptIds)
 	}
+	log.Println("connecting smtpserver", smtpServer.ServerName())
 
-	client, err := smtp.NewClient(conn, smtpServer.host)
+	client, dlvErr, err := dialSMTP(ctx, smtpServer)
 	if err != nil {
 		log.Println(err)
-		return consts.SMTPM_CLT_BLD_ERR
+		return registry.NewDeliveries(mail.rcptIds, dlvErr, err)
 	}
+	defer client.Close()
 
-	//Use Auth
-	if err = client.Auth(auth); err != nil { //authentication failed
-		log.Println(err)
-		return consts.SMTPM_AUTH_ERR
+	//Use Auth, unless the auth mechanism is none (e.g. a relay accepting plain SMTP needs no authentication)
+	if auth != nil {
+		if err = client.Auth(auth); err != nil { //authentication failed
+			log.Println(err)
+			return registry.NewDeliveries(mail.rcptIds, consts.SMTPM_AUTH_ERR, err)
+		}
 	}
 	//add sender and receivers
-	if err = client.Mail(mail.senderID); err != nil {
+	if err = client.Mail(envelopeAddress(mail.senderID)); err != nil {
 		log.Println(err)
-		return consts.SMTPM_SENDER_ERR
+		return registry.NewDeliveries(mail.rcptIds, consts.SMTPM_SENDER_ERR, err)
 	}
-	for _, k := range mail.toIds {
+	//receivers refused by the server are recorded and skipped
+	deliveries := make([]registry.Delivery, len(mail.rcptIds))
+	var accepted []int
+	for i, k := range mail.rcptIds {
 		//no need to verify target addresses
 		//Many servers will not verify addresses for security reasons.
+		if err = client.Rcpt(envelopeAddress(k)); err != nil {
+			log.Println("receiver address:", k, "refused:", err)
+			deliveries[i] = registry.NewDelivery(k, consts.SMTPM_RCVR_ERR, err)
+			continue
+		}
 		log.Println("receiver address: ", k, " added successfully")
-		if err = client.Rcpt(k); err != nil {
-			log.Println(err)
-			return consts.SMTPM_RCVR_ERR
+		accepted = append(accepted, i)
+	}
+	//finish records the same result for all accepted receivers
+	finish := func(code consts.ERR, err error) []registry.Delivery {
+		for _, i := range accepted {
+			deliveries[i] = registry.NewDelivery(mail.rcptIds[i], code, err)
 		}
+		return deliveries
+	}
+	if len(accepted) == 0 {
+		return deliveries
 	}
 
 	//Data
 	w, err := client.Data()
 	if err != nil {
 		log.Println(err)
-		return consts.SMTPM_CLT_IO_ERR
+		return finish(consts.SMTPM_CLT_IO_ERR, err)
 	}
 
 	_, err = w.Write([]byte(msgBody))
 	if err != nil {
 		log.Println(err)
-		return consts.SMTPM_CLT_DATA_ERR
+		return finish(consts.SMTPM_CLT_DATA_ERR, err)
 	}
 
 	err = w.Close()
 	if err != nil {
 		log.Println(err)
-		return consts.SMTPM_CLT_IO_ERR
+		return finish(consts.SMTPM_CLT_IO_ERR, err)
 	}
-	err = client.Quit()
-	if err != nil {
+	//the message has been accepted by the server once the data is closed
+	//so an error while quitting is only logged
+	if err = client.Quit(); err != nil {
 		log.Println(err)
-		return consts.SMTPM_CLT_CLOSE_ERR
 	}
 
-	return consts.SUCCESS
+	return finish(consts.SUCCESS, nil)
 }
 
-func emailNotifyHelp(from string, to []string, subject string, msg string, SMTPHost string, SMTPPort string, pwd string) consts.ERR {
-	mail := newMail(from, to, subject, msg)
-	smtpServer := newSMTPServer(SMTPHost, SMTPPort)
-	return smtpEmail(mail, smtpServer, pwd)
+//dialSMTP connects to the SMTP server and sets up TLS according to its TLS mode
+//connecting gives up after consts.SMTPTimeout or when ctx is done, and so does each read or write of the session
+//returns the ERR of the receivers along with the error if it fails
+func dialSMTP(ctx context.Context, smtpServer *SmtpServer) (*smtp.Client, consts.ERR, error) {
+	dialer := net.Dialer{Timeout: consts.SMTPTimeout}
+	rawConn, err := dialer.DialContext(ctx, "tcp", smtpServer.ServerName())
+	if err != nil { //no such host, refused or timed out
+		return nil, consts.SMTPM_SVR_CONN_ERR, err
+	}
+	var conn net.Conn = newTimeoutConn(ctx, rawConn, consts.SMTPTimeout)
+	if smtpServer.tlsMode == consts.TLSImplicit {
+		tlsConn := tls.Client(conn, smtpServer.tlsconfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			if isCertErr(err) { //a certificate that cannot be verified is not a temporary error
+				return nil, consts.SMTPM_TLS_ERR, err
+			}
+			return nil, consts.SMTPM_SVR_CONN_ERR, err
+		}
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, smtpServer.host)
+	if err != nil {
+		conn.Close()
+		return nil, consts.SMTPM_CLT_BLD_ERR, err
+	}
+	if smtpServer.tlsMode != consts.TLSStartTLS {
+		return client, consts.NIL, nil
+	}
+
+	//never fall back to plain SMTP if STARTTLS is required
+	if ok, _ := client.Extension("STARTTLS"); !ok {
+		client.Close()
+		return nil, consts.SMTPM_TLS_ERR, errors.New("tlsMode is starttls but the server " + smtpServer.ServerName() + " does not advertise STARTTLS")
+	}
+	if err = client.StartTLS(smtpServer.tlsconfig); err != nil {
+		client.Close()
+		return nil, consts.SMTPM_TLS_ERR, err
+	}
+	return client, consts.NIL, nil
+}
+
+//timeoutConn is a connection to an SMTP server whose reads and writes fail
+//after a timeout without any progress, and which is closed as soon as ctx is done
+//so that a server which stops answering never blocks the notification
+type timeoutConn struct {
+	net.Conn
+	ctx       context.Context
+	timeout   time.Duration
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+//newTimeoutConn wraps conn, the connection is closed when ctx is done
+//even if a read or a write is blocked, which then fails with the error of ctx
+func newTimeoutConn(ctx context.Context, conn net.Conn, timeout time.Duration) *timeoutConn {
+	c := &timeoutConn{Conn: conn, ctx: ctx, timeout: timeout, closed: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-c.closed:
+		}
+	}()
+	return c
+}
+
+//Read reads from the connection within the timeout
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	n, err := c.Conn.Read(b)
+	if err != nil && c.ctx.Err() != nil {
+		return n, c.ctx.Err()
+	}
+	return n, err
+}
+
+//Write writes to the connection within the timeout
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	n, err := c.Conn.Write(b)
+	if err != nil && c.ctx.Err() != nil {
+		return n, c.ctx.Err()
+	}
+	return n, err
+}
+
+//Close closes the connection and stops watching ctx
+func (c *timeoutConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
 }
 
-//EmailNotify (to []string, subject, msg string, ntfs Notifiers)
+//printDryRun prints the SMTP transaction that smtpEmail would perform, including the raw DATA
+func printDryRun(w io.Writer, mail *Mail, smtpServer *SmtpServer, msgBody string) {
+	fmt.Fprintln(w, "=== [dry-run] SMTP", smtpServer.ServerName(), "tlsMode:", smtpServer.tlsMode)
+	fmt.Fprintf(w, "MAIL FROM:<%s>\n", envelopeAddress(mail.senderID))
+	for _, k := range mail.rcptIds {
+		fmt.Fprintf(w, "RCPT TO:<%s>\n", envelopeAddress(k))
+	}
+	fmt.Fprintln(w, "DATA")
+	fmt.Fprintln(w, msgBody)
+	fmt.Fprintln(w, ".")
+}
+
+func emailNotifyHelp(ctx context.Context, from string, addrs Addresses, rcpts []string, subject string, msg string, htmlMsg string, attachments []registry.Attachment, SMTPHost string, SMTPPort string, tlsMode string, tlsconfig *tls.Config, auth smtp.Auth) []registry.Delivery {
+	mail := newMail(from, addrs, rcpts, subject, msg, htmlMsg, attachments)
+	smtpServer := newSMTPServer(SMTPHost, SMTPPort, tlsMode, tlsconfig)
+	return smtpEmail(ctx, mail, smtpServer, auth)
+}
+
+//TLSMode returns the TLS mode of an smtpemail notifier in lower case, consts.TLSImplicit if it is not set
+func TLSMode(ntf parsers.SmtpEmailNotifier) string {
+	if ntf.TLSMode == "" {
+		return consts.TLSImplicit
+	}
+	return strings.ToLower(ntf.TLSMode)
+}
+
+//EmailNotify (ctx, addrs Addresses, rcpts []string, subject, msg, htmlMsg string, attachments []Attachment, ntf SmtpEmailNotifier)
 //send an email with subject and message provided with parameters
-//to the email address stored in(to []string)
-func EmailNotify(to []string, subject, msg string, ntfs parsers.Notifiers) consts.ERR {
-	if len(to) == 0 {
-		return consts.SMTPM_NOTGT
+//to the email addresses stored in(rcpts []string), usually addrs.Rcpts()
+//the headers are always built from addrs, so that resending to some receivers does not change them
+//htmlMsg is sent as an alternative to msg if it is not empty, and attachments are attached to the email
+//return the delivery result of each address, or an ERR if nothing was sent
+func EmailNotify(ctx context.Context, addrs Addresses, rcpts []string, subject, msg, htmlMsg string, attachments []registry.Attachment, ntf parsers.SmtpEmailNotifier) ([]registry.Delivery, consts.ERR) {
+	if len(rcpts) == 0 {
+		return nil, consts.SMTPM_NOTGT
 	}
-	ntf := ntfs.SMTPEmailNotifier
 
 	//check the notification type "smtpemail" and find if the state is "on"
 	//if no type of "smtpemail" or the state is "off", do nothing and return directly
-	if ntf.Type == "smtpemail" && (ntf.State == true) {
-		return emailNotifyHelp(ntf.Account, to, subject, msg,
-			ntf.SMTPHost, ntf.SMTPPort, ntf.Pwd)
+	if strings.EqualFold(ntf.Type, consts.SMTPEmailType) && (ntf.State == true) {
+		tlsconfig, err := newTLSConfig(ntf)
+		if err != nil {
+			log.Println(err)
+			return registry.NewDeliveries(rcpts, consts.SMTPM_TLS_ERR, err), consts.SMTPM_TLS_ERR
+		}
+		auth, err := newAuth(ntf)
+		if err != nil {
+			log.Println(err)
+			return registry.NewDeliveries(rcpts, consts.SMTPM_AUTH_ERR, err), consts.SMTPM_AUTH_ERR
+		}
+		deliveries := emailNotifyHelp(ctx, ntf.Account, addrs, rcpts, subject, msg, htmlMsg, attachments,
+			ntf.SMTPHost, ntf.SMTPPort, TLSMode(ntf), tlsconfig, auth)
+		return deliveries, registry.FirstErr(deliveries)
 	}
 
-	return consts.SMTPM_INVAL
+	return nil, consts.SMTPM_INVAL
 }
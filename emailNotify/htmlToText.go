@@ -0,0 +1,57 @@
+package emailNotify
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+//regular expressions used by HTMLToText, in the order they are applied
+var (
+	htmlDropRe    = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>`)
+	htmlCommentRe = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlLinkRe    = regexp.MustCompile(`(?is)<a\b[^>]*?href\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a\s*>`)
+	htmlBreakRe   = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlBlockRe   = regexp.MustCompile(`(?i)</?(p|div|h[1-6]|table|tr|ul|ol|pre|blockquote|hr)\b[^>]*>`)
+	htmlItemRe    = regexp.MustCompile(`(?i)<li\b[^>]*>`)
+	htmlCellRe    = regexp.MustCompile(`(?i)</t[dh]\s*>`)
+	htmlTagRe     = regexp.MustCompile(`(?s)<[^>]*>`)
+	spacesRe      = regexp.MustCompile(`[ \t]+`)
+	blankLinesRe  = regexp.MustCompile(`\n{3,}`)
+)
+
+//HTMLToText generates a plain-text version of an HTML message
+//used as the text/plain alternative of an HTML email when no plain-text message is given
+//links are kept as "text (url)", table cells are separated by tabs and list items become "- item"
+func HTMLToText(htmlMsg string) string {
+	text := htmlDropRe.ReplaceAllString(htmlMsg, "")
+	text = htmlCommentRe.ReplaceAllString(text, "")
+	text = htmlLinkRe.ReplaceAllStringFunc(text, func(link string) string {
+		m := htmlLinkRe.FindStringSubmatch(link)
+		label := strings.TrimSpace(htmlTagRe.ReplaceAllString(m[2], ""))
+		if label == "" || label == m[1] {
+			return m[1]
+		}
+		return label + " (" + m[1] + ")"
+	})
+	//line breaks in the source are not significant in HTML
+	text = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(text)
+	text = htmlBreakRe.ReplaceAllString(text, "\n")
+	text = htmlBlockRe.ReplaceAllString(text, "\n")
+	text = htmlItemRe.ReplaceAllString(text, "\n- ")
+	text = htmlCellRe.ReplaceAllString(text, "\t")
+	text = htmlTagRe.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spacesRe.ReplaceAllStringFunc(line, func(sp string) string {
+			if strings.Contains(sp, "\t") {
+				return "\t"
+			}
+			return " "
+		}))
+	}
+	text = blankLinesRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text)
+}
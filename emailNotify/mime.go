@@ -0,0 +1,200 @@
+package emailNotify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"notifier/registry"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//maximum length of a line in an encoded body (RFC 2045)
+const maxLineLen = 76
+
+//header is a list of mail header fields, written in order
+type header [][2]string
+
+//add appends a header field
+func (h *header) add(key, val string) {
+	*h = append(*h, [2]string{key, val})
+}
+
+//writeTo writes all header fields to buf, each terminated by CRLF
+func (h header) writeTo(buf *bytes.Buffer) {
+	for _, field := range h {
+		buf.WriteString(field[0])
+		buf.WriteString(": ")
+		buf.WriteString(field[1])
+		buf.WriteString("\r\n")
+	}
+}
+
+//mimeEntity is the content of a message or of one of its parts
+//its header contains the Content-* fields only
+type mimeEntity struct {
+	header header
+	body   []byte
+}
+
+//textEntity builds a UTF-8 text entity of a content type (e.g. text/plain, text/html)
+func textEntity(contentType, text string) mimeEntity {
+	encoding := bodyEncoding(text)
+	var hdr header
+	hdr.add("Content-Type", contentType+"; charset=UTF-8")
+	hdr.add("Content-Transfer-Encoding", encoding)
+	return mimeEntity{header: hdr, body: encodeBody([]byte(text), encoding)}
+}
+
+//multipartEntity builds a multipart entity of a subtype (e.g. alternative, mixed) from its parts
+func multipartEntity(subtype string, parts []mimeEntity) mimeEntity {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, part := range parts {
+		partHeader := make(textproto.MIMEHeader)
+		for _, field := range part.header {
+			partHeader.Add(field[0], field[1])
+		}
+		w, _ := mw.CreatePart(partHeader)
+		w.Write(part.body)
+	}
+	mw.Close()
+
+	var hdr header
+	hdr.add("Content-Type", "multipart/"+subtype+"; boundary="+mw.Boundary())
+	return mimeEntity{header: hdr, body: buf.Bytes()}
+}
+
+//attachmentEntity builds a base64 entity of an attached file
+//the file name is set in both Content-Type and Content-Disposition for older mail clients
+func attachmentEntity(att registry.Attachment) mimeEntity {
+	mediaType, params, err := mime.ParseMediaType(att.ContentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", map[string]string{}
+	}
+	params["name"] = att.Name
+	var hdr header
+	hdr.add("Content-Type", mime.FormatMediaType(mediaType, params))
+	hdr.add("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": att.Name}))
+	hdr.add("Content-Transfer-Encoding", "base64")
+	return mimeEntity{header: hdr, body: encodeBody(att.Data, "base64")}
+}
+
+//encodeHeader encodes the value of a header field key (e.g. the subject) with RFC 2047 if it is not plain ASCII
+//the value is folded between the encoded-words so that no line of the field is longer than maxLineLen
+func encodeHeader(key, val string) string {
+	encoded := mime.QEncoding.Encode("utf-8", val)
+	if encoded == val {
+		return val
+	}
+	var folded strings.Builder
+	lineLen := len(key + ": ")
+	for i, word := range strings.Split(encoded, " ") {
+		sep := " "
+		if i == 0 {
+			sep = ""
+		}
+		if lineLen+len(sep+word) > maxLineLen {
+			sep = "\r\n "
+			lineLen = 0
+		}
+		folded.WriteString(sep + word)
+		lineLen += len(strings.TrimPrefix(sep, "\r\n")) + len(word)
+	}
+	return folded.String()
+}
+
+//formatAddress formats an address such as "a@b.com" or "Name <a@b.com>" for a header
+//the display name is encoded with RFC 2047 if needed
+func formatAddress(addr string) string {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil {
+		return addr
+	}
+	return parsed.String()
+}
+
+//formatAddressList formats a list of addresses for a header, separated by commas
+func formatAddressList(addrs []string) string {
+	formatted := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		formatted = append(formatted, formatAddress(addr))
+	}
+	return strings.Join(formatted, ",\r\n ")
+}
+
+//envelopeAddress returns the bare address used in the SMTP envelope (MAIL FROM, RCPT TO)
+//e.g. "a@b.com" for "Name <a@b.com>"
+func envelopeAddress(addr string) string {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil {
+		return addr
+	}
+	return parsed.Address
+}
+
+//newMessageID generates a unique Message-ID in the domain of the sender
+func newMessageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(envelopeAddress(from), "@"); at >= 0 {
+		domain = envelopeAddress(from)[at+1:]
+	}
+	random := make([]byte, 12)
+	rand.Read(random)
+	return "<" + strconv.FormatInt(time.Now().UnixNano(), 36) + "." + hex.EncodeToString(random) + "@" + domain + ">"
+}
+
+//truncateUTF8 trims s to at most max bytes without splitting a multi-byte rune
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
+
+//bodyEncoding chooses the Content-Transfer-Encoding of a text body
+//base64 for mostly non-ASCII text (e.g. Japanese), quoted-printable otherwise
+func bodyEncoding(body string) string {
+	nonASCII := 0
+	for i := 0; i < len(body); i++ {
+		if body[i] >= 0x80 {
+			nonASCII++
+		}
+	}
+	if nonASCII*3 > len(body) {
+		return "base64"
+	}
+	return "quoted-printable"
+}
+
+//encodeBody encodes a body with the transfer encoding, using CRLF line breaks
+func encodeBody(body []byte, encoding string) []byte {
+	var buf bytes.Buffer
+	switch encoding {
+	case "base64":
+		encoded := base64.StdEncoding.EncodeToString(body)
+		for len(encoded) > maxLineLen {
+			buf.WriteString(encoded[:maxLineLen])
+			buf.WriteString("\r\n")
+			encoded = encoded[maxLineLen:]
+		}
+		buf.WriteString(encoded)
+	default:
+		qp := quotedprintable.NewWriter(&buf)
+		qp.Write(body)
+		qp.Close()
+	}
+	return buf.Bytes()
+}
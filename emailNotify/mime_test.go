@@ -0,0 +1,286 @@
+package emailNotify
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"notifier/registry"
+	"strings"
+	"testing"
+)
+
+//unfold joins the folded lines of a header value
+func unfold(val string) string {
+	return strings.Replace(val, "\r\n ", " ", -1)
+}
+
+func TestEncodeHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		val  string
+		want string //checked if not empty
+	}{
+		{"ascii", "Build failed", "Build failed"},
+		{"long ascii", strings.Repeat("word ", 30), strings.Repeat("word ", 30)},
+		{"accent", "Café", "=?utf-8?q?Caf=C3=A9?="},
+		{"japanese", "ビルドが失敗しました", ""},
+		{"long", strings.Repeat("Résumé de la compilation ", 10), ""},
+		{"long japanese", strings.Repeat("通知のテスト", 20), ""},
+		{"specials", "a=b ?c_d", ""},
+	}
+	var dec mime.WordDecoder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodeHeader("Subject", tt.val)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("encodeHeader(%q) = %q, want %q", tt.val, got, tt.want)
+			}
+			if got == tt.val {
+				return
+			}
+			for i, line := range strings.Split("Subject: "+got, "\r\n") {
+				if len(line) > maxLineLen {
+					t.Errorf("line %d %q is longer than %d", i+1, line, maxLineLen)
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("line %d %q is not folded", i+1, line)
+				}
+			}
+			//the first encoded-word may be folded onto the second line, after "Subject: "
+			decoded, err := dec.DecodeHeader(strings.TrimSpace(unfold(got)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded != tt.val {
+				t.Errorf("encodeHeader(%q) decodes to %q", tt.val, decoded)
+			}
+		})
+	}
+}
+
+func TestFormatAddress(t *testing.T) {
+	tests := []struct {
+		addr, want, envelope string
+	}{
+		{"a@b.com", "<a@b.com>", "a@b.com"},
+		{"Build Bot <bot@ci.example.com>", "\"Build Bot\" <bot@ci.example.com>", "bot@ci.example.com"},
+		{"Jörg <j@example.de>", "=?utf-8?q?J=C3=B6rg?= <j@example.de>", "j@example.de"},
+		//an address which cannot be parsed is written as it is
+		{"not an address", "not an address", "not an address"},
+	}
+	for _, tt := range tests {
+		if got := formatAddress(tt.addr); got != tt.want {
+			t.Errorf("formatAddress(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+		if got := envelopeAddress(tt.addr); got != tt.envelope {
+			t.Errorf("envelopeAddress(%q) = %q, want %q", tt.addr, got, tt.envelope)
+		}
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 3, "hel"},
+		{"日本語", 3, "日"},
+		{"日本語", 5, "日"},
+		{"日本語", 6, "日本"},
+		{"aé", 2, "a"},
+	}
+	for _, tt := range tests {
+		if got := truncateUTF8(tt.s, tt.max); got != tt.want {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestBodyEncoding(t *testing.T) {
+	tests := []struct {
+		body, want string
+	}{
+		{"plain text", "quoted-printable"},
+		{"", "quoted-printable"},
+		{"Café au lait", "quoted-printable"},
+		{"ビルドが失敗しました", "base64"},
+	}
+	for _, tt := range tests {
+		if got := bodyEncoding(tt.body); got != tt.want {
+			t.Errorf("bodyEncoding(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+//checkLines fails if a line of an encoded body is longer than maxLineLen or is not terminated by CRLF
+func checkLines(t *testing.T, encoded []byte) {
+	t.Helper()
+	if bytes.Contains(bytes.Replace(encoded, []byte("\r\n"), nil, -1), []byte("\n")) {
+		t.Errorf("encoded body %q has bare LF line breaks", encoded)
+	}
+	for i, line := range strings.Split(string(encoded), "\r\n") {
+		if len(line) > maxLineLen {
+			t.Errorf("line %d of the encoded body is %d characters long", i+1, len(line))
+		}
+	}
+}
+
+func TestEncodeBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		encoding string
+	}{
+		{"qp short", "hello\nworld\n", "quoted-printable"},
+		{"qp long line", strings.Repeat("a long line of text ", 20), "quoted-printable"},
+		{"qp trailing space", "end of line \nnext", "quoted-printable"},
+		{"qp equals and accents", "x = y, café = 1\n", "quoted-printable"},
+		{"base64 short", "日本語", "base64"},
+		{"base64 long", strings.Repeat("通知のテスト\n", 30), "base64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encodeBody([]byte(tt.body), tt.encoding)
+			checkLines(t, encoded)
+			var decoded []byte
+			var err error
+			want := tt.body
+			if tt.encoding == "base64" {
+				decoded, err = base64.StdEncoding.DecodeString(strings.Replace(string(encoded), "\r\n", "", -1))
+			} else {
+				//quoted-printable text has CRLF line breaks
+				decoded, err = ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(encoded)))
+				want = strings.Replace(tt.body, "\n", "\r\n", -1)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decoded) != want {
+				t.Errorf("encodeBody(%q, %s) decodes to %q", tt.body, tt.encoding, decoded)
+			}
+		})
+	}
+}
+
+//part is a decoded leaf part of a message
+type part struct {
+	contentType string
+	body        string
+}
+
+//readParts decodes the leaf parts of an entity, in order
+func readParts(t *testing.T, contentType, encoding string, body []byte) []part {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		var decoded []byte
+		switch encoding {
+		case "base64":
+			decoded, err = base64.StdEncoding.DecodeString(strings.Replace(string(body), "\r\n", "", -1))
+		default:
+			decoded, err = ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []part{{mediaType, string(decoded)}}
+	}
+	var parts []part
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, readParts(t, p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), data)...)
+	}
+	return parts
+}
+
+func TestBuildMessage(t *testing.T) {
+	attachment := registry.Attachment{Name: "build.log", ContentType: "text/plain", Data: []byte("line 1\nline 2\n")}
+	tests := []struct {
+		name        string
+		mail        *Mail
+		contentType string
+		parts       []part
+	}{
+		{
+			name:        "plain",
+			mail:        newMail("ci@example.com", Addresses{To: []string{"a@example.com"}}, nil, "Build ok", "done\n", "", nil),
+			contentType: "text/plain",
+			parts:       []part{{"text/plain", "done\r\n"}},
+		},
+		{
+			name:        "html",
+			mail:        newMail("ci@example.com", Addresses{To: []string{"a@example.com"}}, nil, "Build ok", "done", "<p>done</p>", nil),
+			contentType: "multipart/alternative",
+			parts:       []part{{"text/plain", "done"}, {"text/html", "<p>done</p>"}},
+		},
+		{
+			name: "html and attachment",
+			mail: newMail("ci@example.com", Addresses{To: []string{"a@example.com"}}, nil, "ビルド失敗", "失敗しました", "<p>失敗しました</p>",
+				[]registry.Attachment{attachment}),
+			contentType: "multipart/mixed",
+			parts:       []part{{"text/plain", "失敗しました"}, {"text/html", "<p>失敗しました</p>"}, {"text/plain", "line 1\nline 2\n"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := mail.ReadMessage(strings.NewReader(tt.mail.BuildMessage()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dec mime.WordDecoder
+			if subject, err := dec.DecodeHeader(msg.Header.Get("Subject")); err != nil || subject != tt.mail.subject {
+				t.Errorf("Subject = %q (%v), want %q", subject, err, tt.mail.subject)
+			}
+			for _, key := range []string{"From", "To", "Date", "Message-Id", "Mime-Version"} {
+				if msg.Header.Get(key) == "" {
+					t.Errorf("no %s header", key)
+				}
+			}
+			contentType := msg.Header.Get("Content-Type")
+			if !strings.HasPrefix(contentType, tt.contentType) {
+				t.Errorf("Content-Type = %q, want %s", contentType, tt.contentType)
+			}
+			body, err := ioutil.ReadAll(msg.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts := readParts(t, contentType, msg.Header.Get("Content-Transfer-Encoding"), body)
+			if len(parts) != len(tt.parts) {
+				t.Fatalf("message has parts %v, want %v", parts, tt.parts)
+			}
+			for i := range parts {
+				if parts[i] != tt.parts[i] {
+					t.Errorf("part %d = %+v, want %+v", i+1, parts[i], tt.parts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildMessageBcc(t *testing.T) {
+	addrs := Addresses{Bcc: []string{"hidden@example.com"}}
+	msg := newMail("ci@example.com", addrs, addrs.Rcpts(), "s", "b", "", nil).BuildMessage()
+	if strings.Contains(msg, "hidden@example.com") {
+		t.Errorf("the Bcc address is written in the message:\n%s", msg)
+	}
+	if !strings.Contains(msg, "To: undisclosed-recipients:;\r\n") {
+		t.Errorf("no undisclosed-recipients To header:\n%s", msg)
+	}
+}
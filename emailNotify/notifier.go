@@ -0,0 +1,97 @@
+package emailNotify
+
+import (
+	"context"
+	"log"
+	"notifier/consts"
+	"notifier/parsers"
+	"notifier/registry"
+	"strings"
+)
+
+func init() {
+	registry.Register(consts.SMTPEmailType, newSmtpEmailNotifier)
+}
+
+//smtpEmailNotifier is the registry.Notifier of type "smtpemail"
+type smtpEmailNotifier struct {
+	name  string
+	ntf   parsers.SmtpEmailNotifier
+	retry parsers.RetryPolicy
+}
+
+//newSmtpEmailNotifier builds a smtpEmailNotifier from its settings in notifyrcFile
+func newSmtpEmailNotifier(cfg parsers.NotifierConfig) (registry.Notifier, consts.ERR) {
+	n := &smtpEmailNotifier{name: cfg.Name, retry: cfg.Retry}
+	if err := cfg.Decode(&n.ntf); err != nil {
+		log.Println(err)
+		return nil, consts.NOTIFRC_PARSE_ERR
+	}
+	switch TLSMode(n.ntf) {
+	case consts.TLSImplicit, consts.TLSStartTLS, consts.TLSNone:
+	default:
+		log.Println("unknown tlsMode \""+n.ntf.TLSMode+"\" of notifier", cfg.Name, "(implicit, starttls or none)")
+		return nil, consts.NOTIFRC_PARSE_ERR
+	}
+	switch AuthMech(n.ntf) {
+	case consts.AuthPlain, consts.AuthLogin, consts.AuthCRAMMD5, consts.AuthXOAUTH2, consts.AuthNone:
+	default:
+		log.Println("unknown auth \""+n.ntf.Auth+"\" of notifier", cfg.Name, "(plain, login, cram-md5, xoauth2 or none)")
+		return nil, consts.NOTIFRC_PARSE_ERR
+	}
+	return n, consts.NIL
+}
+
+//Name returns the name of the notifier in notifyrcFile
+func (n *smtpEmailNotifier) Name() string {
+	return n.name
+}
+
+//Validate checks the notification type "smtpemail" and if the state is "on"
+func (n *smtpEmailNotifier) Validate() consts.ERR {
+	if strings.EqualFold(n.ntf.Type, consts.SMTPEmailType) && n.ntf.State {
+		return consts.NIL
+	}
+	return consts.SMTPM_INVAL
+}
+
+//Send sends the notification to its email recipients (To, Cc and Bcc)
+//the recipients failed with a temporary error are retried according to the retry policy
+func (n *smtpEmailNotifier) Send(ctx context.Context, ntf registry.Notification) registry.Result {
+	return n.Resend(ctx, ntf, addresses(ntf).Rcpts())
+}
+
+//Resend sends the notification to the specified email addresses only
+//the headers (To, Cc, Reply-To) are the same as those of Send
+func (n *smtpEmailNotifier) Resend(ctx context.Context, ntf registry.Notification, rcpts []string) registry.Result {
+	addrs := addresses(ntf)
+	subject := levelSubject(ntf.Level, ntf.Subject)
+	deliveries, err := EmailNotify(ctx, addrs, rcpts, subject, ntf.Message, ntf.HTML, ntf.Attachments, n.ntf)
+	if len(deliveries) > 0 {
+		deliveries = registry.Retry(ctx, n.retry, deliveries, func(rcpts []string) []registry.Delivery {
+			retried, _ := EmailNotify(ctx, addrs, rcpts, subject, ntf.Message, ntf.HTML, ntf.Attachments, n.ntf)
+			return retried
+		})
+		err = registry.FirstErr(deliveries)
+	}
+	return registry.NewResult(n.name, n.ntf.Type, deliveries, err)
+}
+
+//levelSubject prefixes the subject with the level of the notification, e.g. "[CRITICAL] disk full"
+//the subject is unchanged if no level was given
+func levelSubject(level consts.Level, subject string) string {
+	if level == consts.LevelNone {
+		return subject
+	}
+	return "[" + strings.ToUpper(level.String()) + "] " + subject
+}
+
+//addresses returns the email addresses of a notification
+func addresses(ntf registry.Notification) Addresses {
+	return Addresses{
+		To:      ntf.To(consts.EmailRecipients),
+		Cc:      ntf.To(consts.EmailCcRecipients),
+		Bcc:     ntf.To(consts.EmailBccRecipients),
+		ReplyTo: ntf.ReplyTo,
+	}
+}
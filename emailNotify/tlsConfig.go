@@ -0,0 +1,67 @@
+package emailNotify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"notifier/parsers"
+)
+
+//newTLSConfig builds the TLS setting of an smtpemail notifier
+//the server certificate is verified against the system CA pool, or caFile if it is set
+//a client certificate is presented if certFile and keyFile are set (mTLS relays)
+func newTLSConfig(ntf parsers.SmtpEmailNotifier) (*tls.Config, error) {
+	tlsconfig := &tls.Config{
+		ServerName: ntf.SMTPHost,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if ntf.MinTLSVersion != "" {
+		version, ok := parsers.ParseTLSVersion(ntf.MinTLSVersion)
+		if !ok {
+			return nil, errors.New("unknown minTLSVersion \"" + ntf.MinTLSVersion + "\" (1.0, 1.1, 1.2 or 1.3)")
+		}
+		tlsconfig.MinVersion = version
+	}
+
+	if ntf.CAFile != "" {
+		pem, err := ioutil.ReadFile(ntf.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in caFile " + ntf.CAFile)
+		}
+		tlsconfig.RootCAs = pool
+	}
+
+	if ntf.CertFile != "" || ntf.KeyFile != "" {
+		if ntf.CertFile == "" || ntf.KeyFile == "" {
+			return nil, errors.New("both certFile and keyFile are needed for a client certificate")
+		}
+		cert, err := tls.LoadX509KeyPair(ntf.CertFile, ntf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsconfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if ntf.InsecureSkipVerify {
+		log.Println("WARNING: insecureSkipVerify is on, the certificate of", ntf.SMTPHost,
+			"is not verified and the connection is open to man-in-the-middle attacks")
+		tlsconfig.InsecureSkipVerify = true
+	}
+
+	return tlsconfig, nil
+}
+
+//isCertErr reports whether err is caused by a server certificate that cannot be verified
+func isCertErr(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid)
+}
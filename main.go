@@ -1,6 +1,8 @@
 package main
 
 import (
+	"log"
+	"notifier/parsers"
 	"os"
 	"sort"
 
@@ -8,6 +10,9 @@ import (
 )
 
 func main() {
+	//never log the passwords and tokens in notifyrcFile
+	log.SetOutput(parsers.RedactWriter(os.Stderr))
+
 	//build a new app with cli package, and specify some info
 	app := appInit()
 
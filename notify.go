@@ -1,103 +1,303 @@
 package main
 
 import (
+	"context"
 	"log"
 	"notifier/consts"
-	eml "notifier/emailNotify"
+	_ "notifier/emailNotify"
+	"notifier/outbox"
 	"notifier/parsers"
-	slk "notifier/slackNotify"
-	"runtime"
+	"notifier/registry"
+	_ "notifier/slackNotify"
+	_ "notifier/webhookNotify"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/urfave/cli"
 )
 
+//buildNotification collects the global input parameters into a notification
+func buildNotification() registry.Notification {
+	return registry.Notification{
+		Subject: Subject,
+		Message: Message,
+		Level:   Level,
+		HTML:    HTMLMessage,
+		Blocks:  Blocks,
+		Recipients: map[string][]string{
+			consts.EmailRecipients:    ToEmailAddrs,
+			consts.EmailCcRecipients:  CcEmailAddrs,
+			consts.EmailBccRecipients: BccEmailAddrs,
+			consts.SlackRecipients:    ToSlackUsers,
+		},
+		ReplyTo:     ReplyToAddrs,
+		Attachments: Attachments,
+	}
+}
+
+//enabledNotifiers parses notifiers from notifyrcFile
+//and builds those whose state is on
+//only the notifiers named by ViaNotifiers are built if it is not empty
+//and only those whose minLevel is at most Level
+func enabledNotifiers() ([]registry.Notifier, consts.ERR) {
+	ntfs, err := parsers.ParseNotifiers(parsers.NotifyrcFile)
+	if err != consts.NIL {
+		return nil, err
+	}
+	if len(ViaNotifiers) > 0 {
+		if ntfs, err = ntfs.Select(ViaNotifiers); err != consts.NIL {
+			return nil, err
+		}
+	}
+	return registry.Enabled(ntfs.ForLevel(Level))
+}
+
+//sendContext returns the context of sending notifications
+//which is in dry-run mode (printing to stdout) if --dry-run is set
+func sendContext() context.Context {
+	ctx := context.Background()
+	if DryRun {
+		ctx = registry.WithDryRun(ctx, parsers.RedactWriter(os.Stdout))
+	}
+	return ctx
+}
+
+//send validates a notifier and sends the notification with it
+func send(ctx context.Context, ntf registry.Notifier, notif registry.Notification) registry.Result {
+	if err := ntf.Validate(); err != consts.NIL {
+		return registry.Result{Notifier: ntf.Name(), Err: err}
+	}
+	return ntf.Send(ctx, notif)
+}
+
+//checkResult logs the result of one notifier
+//and returns the ERR the app should exit with (NIL if nothing went wrong)
+func checkResult(res registry.Result) consts.ERR {
+	switch res.Err {
+	case consts.NIL:
+		log.Println(res.Notifier, "notification success")
+	case consts.SMTPM_INVAL, consts.SLK_INVAL, consts.WHK_INVAL:
+		log.Println(res.Notifier, "notification invalid")
+	case consts.SMTPM_NOTGT:
+		log.Println("no target email address(es) for", res.Notifier)
+	case consts.SLK_NOTGT:
+		log.Println("no target slack users(channels) for", res.Notifier)
+	default:
+		return res.Err
+	}
+	return consts.NIL
+}
+
+//exitWith prints the delivery report and checks all results in order
+//the app exits with the first ERR found if nothing was delivered at all,
+//or with PARTIAL_FAIL if some notifications were delivered while others failed
+func exitWith(results []registry.Result) error {
+	printReport(results)
+
+	exitErr := consts.NIL
+	delivered := false
+	for _, res := range results {
+		if res.Err == consts.NIL || res.Succeeded() > 0 {
+			delivered = true
+		}
+		if err := checkResult(res); err != consts.NIL && exitErr == consts.NIL {
+			exitErr = err
+		}
+	}
+	if exitErr != consts.NIL && delivered {
+		log.Println("some notifications failed, see the report above")
+		exitErr = consts.PARTIAL_FAIL
+	}
+	if exitErr != consts.NIL {
+		cli.OsExiter(int(exitErr))
+	}
+	return nil
+}
+
+//queueFailed stores the deliveries failed with a temporary error in the outbox
+//so that they can be sent again later with the flush command
+func queueFailed(results []registry.Result, notif registry.Notification) {
+	if NoOutbox || DryRun {
+		return
+	}
+	queued := 0
+	for _, res := range results {
+		n, err := outbox.Enqueue(res, notif)
+		queued += n
+		if err != nil {
+			log.Println("failed to queue deliveries in the outbox:", err)
+		}
+	}
+	if queued > 0 {
+		log.Println(queued, "failed delivery(ies) queued in", outbox.Dir(), ", use the flush command to send them again")
+	}
+}
+
 //MultiRoutineNotify operates all possible notifications
-//with multi goroutines
-//can increase CPUS with runtime.GOMAXPROCS(2)
+//with one goroutine for each enabled notifier
 func MultiRoutineNotify() error {
-	//parse notifiers from notifyrcFile
-	ntfs, err := parsers.ParseNotifiers(consts.NotifyrcFile)
+	ntfs, err := enabledNotifiers()
 	if err != consts.NIL {
 		return cli.NewExitError("", int(err))
 	}
+	notif := buildNotification()
+	ctx := sendContext()
 
-	//dedicate 2 CPUs to two notifiers
-	runtime.GOMAXPROCS(2)
-
-	//ERR channel of each routine
-	var EmailERR consts.ERR
-	var SlackERR consts.ERR
-	chEmailERR := make(chan consts.ERR)
-	chSlackERR := make(chan consts.ERR)
-
-	//do email notify
-	go func() {
-		err := eml.EmailNotify(ToEmailAddrs, Subject, Message, ntfs)
-		chEmailERR <- err
-	}()
-
-	//do slack notify
-	go func() {
-		_, _, err := slk.SlackNotify(ToSlackUsers, Subject, Message, ntfs)
-		chSlackERR <- err
-	}()
-
-	//get ERR from channels
-	EmailERR = <-chEmailERR
-	SlackERR = <-chSlackERR
-
-	//check email ERR status
-	if err := EmailERR; err == consts.NIL {
-		log.Println("email notification success")
-	} else if err == consts.SMTPM_INVAL {
-		log.Println("email notification invalid")
-	} else if err == consts.SMTPM_NOTGT {
-		log.Println("no target email address(es)")
-	} else {
-		cli.OsExiter(int(err))
-	}
-
-	//check slack ERR status
-	if err := SlackERR; err == consts.NIL {
-		log.Println("slack notification success")
-	} else if err == consts.SLK_NOTGT {
-		log.Println("no target slack users(channels)")
-	} else if err == consts.SLK_INVAL {
-		log.Println("slack notification invalid")
-	} else {
-		cli.OsExiter(int(err))
+	//each routine writes its own slot, so results keep the notifiers order
+	results := make([]registry.Result, len(ntfs))
+	var wg sync.WaitGroup
+	for i, ntf := range ntfs {
+		wg.Add(1)
+		go func(i int, ntf registry.Notifier) {
+			defer wg.Done()
+			results[i] = send(ctx, ntf, notif)
+		}(i, ntf)
 	}
-	return nil
+	wg.Wait()
+
+	queueFailed(results, notif)
+	return exitWith(results)
 }
 
 //GenNotify operate all possible notifications
+//one notifier after another
 func GenNotify() error {
-	//parse notifiers from notifiers config file
-	ntfs, err := parsers.ParseNotifiers(consts.NotifyrcFile)
+	ntfs, err := enabledNotifiers()
 	if err != consts.NIL {
 		return cli.NewExitError("", int(err))
 	}
+	notif := buildNotification()
+	ctx := sendContext()
 
-	//do email notify
-	if err := eml.EmailNotify(ToEmailAddrs, Subject, Message, ntfs); err == consts.NIL {
-		log.Println("email notification success")
-	} else if err == consts.SMTPM_INVAL {
-		log.Println("email notification invalid")
-	} else if err == consts.SMTPM_NOTGT {
-		log.Println("no target email address(es)")
-	} else {
-		defer cli.OsExiter(int(err))
+	results := make([]registry.Result, 0, len(ntfs))
+	for _, ntf := range ntfs {
+		results = append(results, send(ctx, ntf, notif))
 	}
 
-	//do slack notify
-	if _, _, err := slk.SlackNotify(ToSlackUsers, Subject, Message, ntfs); err == consts.NIL {
-		log.Println("slack notification success")
-	} else if err == consts.SLK_NOTGT {
-		log.Println("no target slack users(channels)")
-	} else if err == consts.SLK_INVAL {
-		log.Println("slack notification invalid")
-	} else {
-		defer cli.OsExiter(int(err))
+	queueFailed(results, notif)
+	return exitWith(results)
+}
+
+//FlushOutbox sends again the notifications queued in the outbox
+//entries older than maxAge are dropped, and so are those failed with a permanent error
+//entries failed with a temporary error again are kept for the next flush
+func FlushOutbox(maxAge time.Duration) error {
+	entries, err := outbox.Load()
+	if err != nil {
+		log.Println(err)
+		return cli.NewExitError("", int(consts.GENERAL_ERR))
+	}
+	ntfs, perr := parsers.ParseNotifiers(parsers.NotifyrcFile)
+	if perr != consts.NIL {
+		return cli.NewExitError("", int(perr))
+	}
+	ctx := context.Background()
+
+	//drop the expired entries
+	var pending []outbox.Entry
+	for _, entry := range entries {
+		if time.Since(entry.Created) > maxAge {
+			log.Println("outbox entry to", entry.Recipient, "via", entry.Notifier, "expired, dropped")
+			outbox.Remove(entry)
+			continue
+		}
+		pending = append(pending, entry)
+	}
+	if len(pending) == 0 {
+		log.Println("outbox is empty")
+		return nil
+	}
+
+	var results []registry.Result
+	for _, batch := range outbox.Batches(pending) {
+		cfg, ok := ntfs[batch.Notifier]
+		if !ok || !cfg.State {
+			log.Println("notifier", batch.Notifier, "is not found or off, its outbox entries are kept")
+			continue
+		}
+		ntf, err := registry.New(cfg)
+		if err != consts.NIL {
+			return cli.NewExitError("", int(err))
+		}
+
+		if keyer, ok := ntf.(registry.RecipientKeyer); ok {
+			if batch = matchingEntries(batch, keyer); len(batch.Entries) == 0 {
+				continue
+			}
+		}
+
+		var res registry.Result
+		if err := ntf.Validate(); err != consts.NIL {
+			res = registry.Result{Notifier: ntf.Name(), Err: err}
+		} else if resender, ok := ntf.(registry.Resender); ok {
+			res = resender.Resend(ctx, batch.Notification, batch.Recipients())
+		} else {
+			res = ntf.Send(ctx, batch.Notification)
+		}
+		results = append(results, res)
+		updateOutbox(batch, res)
+	}
+
+	return exitWith(results)
+}
+
+//matchingEntries keeps the entries of a batch whose recipient has the key it was queued with (see registry.RecipientKeyer)
+//the other entries (e.g. of a webhook url removed or moved since) are not sent, and are kept in the outbox until they expire
+func matchingEntries(batch outbox.Batch, keyer registry.RecipientKeyer) outbox.Batch {
+	entries := batch.Entries
+	batch.Entries = nil
+	for _, entry := range entries {
+		if entry.RecipientKey != "" && keyer.RecipientKey(entry.Recipient) != entry.RecipientKey {
+			log.Println("the url of", entry.Recipient, "via", entry.Notifier, "changed since it was queued, its outbox entry is kept")
+			continue
+		}
+		batch.Entries = append(batch.Entries, entry)
+	}
+	return batch
+}
+
+//updateOutbox removes the entries of a batch delivered (or failed permanently) by the flush
+//and records the new error of those failed with a temporary error again
+//the other deliveries of the flush failed with a temporary error (e.g. the files uploaded after a slack message) are queued
+func updateOutbox(batch outbox.Batch, res registry.Result) {
+	deliveries := make(map[string]registry.Delivery)
+	for _, dlv := range res.Deliveries {
+		deliveries[dlv.Recipient] = dlv
+	}
+	queued := make(map[string]bool)
+	for _, entry := range batch.Entries {
+		queued[entry.Recipient] = true
+	}
+	others := res
+	others.Deliveries = nil
+	for _, dlv := range res.Deliveries {
+		if !queued[dlv.Recipient] {
+			others.Deliveries = append(others.Deliveries, dlv)
+		}
+	}
+	if _, err := outbox.Enqueue(others, batch.Notification); err != nil {
+		log.Println(err)
+	}
+	for _, entry := range batch.Entries {
+		dlv, ok := deliveries[entry.Recipient]
+		if !ok {
+			//the notifier doesn't report this recipient, rely on the whole result
+			dlv = registry.Delivery{Err: res.Err}
+		}
+		if dlv.Err.Retryable() {
+			entry.Err, entry.Detail = dlv.Err, dlv.Detail
+			entry.Flushes++
+			if err := outbox.Save(entry); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		if dlv.Err != consts.NIL {
+			log.Println("delivery to", entry.Recipient, "via", entry.Notifier, "failed permanently, dropped from the outbox")
+		}
+		if err := outbox.Remove(entry); err != nil {
+			log.Println(err)
+		}
 	}
-	return nil
 }
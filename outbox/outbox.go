@@ -0,0 +1,305 @@
+package outbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"notifier/consts"
+	"notifier/registry"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Entry is a delivery to one recipient that failed with a temporary error
+//and waits in the outbox to be sent again
+//the notification is stored once for all its entries, see NotificationID
+type Entry struct {
+	ID             string                //identical deliveries have the same ID, see entryID
+	Notifier       string                //name of the notifier in the config file
+	Type           string                //type of the notifier
+	Recipient      string                //recipient of the failed delivery
+	RecipientKey   string                `json:",omitempty"` //stable key of the recipient if it is a label, see registry.Delivery.Key
+	NotificationID string                //ID of the notification stored in the outbox, see NotificationID
+	Notification   registry.Notification `json:"-"` //the whole notification, with all its original recipients (set by Load)
+	Err            consts.ERR            //ERR of the last failed attempt
+	Detail         string                //error message of the last failed attempt
+	Created        time.Time             //time of the first failed delivery
+	Flushes        int                   //number of times the entry has been flushed
+}
+
+//Dir returns the outbox directory, $HOME/.notifier/outbox by default
+func Dir() string {
+	return filepath.Join(os.Getenv("HOME"), consts.OutboxDir)
+}
+
+//notificationsDir returns the directory of the notifications referenced by the entries
+func notificationsDir() string {
+	return filepath.Join(Dir(), "notifications")
+}
+
+//NotificationID identifies a notification by its whole content
+//(subject, message, HTML, level, recipients, blocks, reply-to and attachments)
+func NotificationID(ntf registry.Notification) (string, error) {
+	data, err := json.Marshal(ntf)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:32], nil
+}
+
+//entryID identifies a delivery by its notifier, recipient (and its key if any) and notification
+//so that the same notification is never queued (and replayed) twice for a recipient
+func entryID(notifier, recipient, key, notificationID string) string {
+	h := sha256.New()
+	ids := []string{notifier, recipient, notificationID}
+	if key != "" {
+		ids = append(ids, key)
+	}
+	for _, s := range ids {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+//path returns the file of an entry in the outbox directory
+func path(id string) string {
+	return filepath.Join(Dir(), id+".json")
+}
+
+//notificationPath returns the file of a notification in the outbox directory
+func notificationPath(id string) string {
+	return filepath.Join(notificationsDir(), id+".json")
+}
+
+//Enqueue stores the deliveries of a result that failed with a temporary error
+//returns the number of entries queued
+//the notification is stored once, however many recipients failed
+//an entry already in the outbox is kept as it is, so that its creation time is not reset
+func Enqueue(res registry.Result, ntf registry.Notification) (int, error) {
+	var failed []registry.Delivery
+	for _, dlv := range res.Deliveries {
+		if dlv.Err.Retryable() {
+			failed = append(failed, dlv)
+		}
+	}
+	if len(failed) == 0 {
+		return 0, nil
+	}
+	ntfID, err := NotificationID(ntf)
+	if err != nil {
+		return 0, err
+	}
+	if err := saveNotification(ntfID, ntf); err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, dlv := range failed {
+		entry := Entry{
+			ID:             entryID(res.Notifier, dlv.Recipient, dlv.Key, ntfID),
+			Notifier:       res.Notifier,
+			Type:           res.Type,
+			Recipient:      dlv.Recipient,
+			RecipientKey:   dlv.Key,
+			NotificationID: ntfID,
+			Notification:   ntf,
+			Err:            dlv.Err,
+			Detail:         dlv.Detail,
+			Created:        dlv.Time,
+		}
+		if _, err := os.Stat(path(entry.ID)); err == nil {
+			log.Println("delivery to", dlv.Recipient, "via", res.Notifier, "is already in the outbox")
+			continue
+		}
+		if err := Save(entry); err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+//saveNotification writes a notification to the outbox directory unless it is already there
+func saveNotification(id string, ntf registry.Notification) error {
+	if _, err := os.Stat(notificationPath(id)); err == nil {
+		return nil
+	}
+	data, err := json.Marshal(ntf)
+	if err != nil {
+		return err
+	}
+	return writeFile(notificationsDir(), notificationPath(id), data)
+}
+
+//Save writes an entry to the outbox directory (without its notification, see Enqueue)
+func Save(entry Entry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(Dir(), path(entry.ID), data)
+}
+
+//writeFile writes data to a temporary file in dir and renames it to path
+//so that a crash never leaves a broken file in the outbox
+func writeFile(dir, path string, data []byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+//Remove deletes an entry from the outbox directory
+//and its notification as well if no other entry refers to it
+func Remove(entry Entry) error {
+	err := os.Remove(path(entry.ID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if entry.NotificationID == "" {
+		return nil
+	}
+	entries, err := loadEntries()
+	if err != nil {
+		return err
+	}
+	for _, other := range entries {
+		if other.NotificationID == entry.NotificationID {
+			return nil
+		}
+	}
+	err = os.Remove(notificationPath(entry.NotificationID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//Load reads all the entries in the outbox directory with their notifications, the oldest first
+//files that cannot be parsed, and entries whose notification cannot be read, are logged and skipped
+func Load() ([]Entry, error) {
+	entries, err := loadEntries()
+	if err != nil {
+		return nil, err
+	}
+	notifications := make(map[string]*registry.Notification)
+	loaded := entries[:0]
+	for _, entry := range entries {
+		ntf, ok := notifications[entry.NotificationID]
+		if !ok {
+			ntf = loadNotification(entry.NotificationID)
+			notifications[entry.NotificationID] = ntf
+		}
+		if ntf == nil {
+			log.Println("notification of outbox entry", entry.ID, "cannot be read, skipped")
+			continue
+		}
+		entry.Notification = *ntf
+		loaded = append(loaded, entry)
+	}
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].Created.Before(loaded[j].Created)
+	})
+	return loaded, nil
+}
+
+//loadEntries reads all the entries in the outbox directory, without their notifications
+func loadEntries() ([]Entry, error) {
+	files, err := ioutil.ReadDir(Dir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(Dir(), file.Name()))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			log.Println("broken outbox entry", file.Name(), ":", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+//loadNotification reads a notification stored by Enqueue, or returns nil if it cannot be read
+func loadNotification(id string) *registry.Notification {
+	if id == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(notificationPath(id))
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	var ntf registry.Notification
+	if err := json.Unmarshal(data, &ntf); err != nil {
+		log.Println("broken outbox notification", id, ":", err)
+		return nil
+	}
+	return &ntf
+}
+
+//Batch is the entries of the same notifier and the same notification
+//which can be sent again at once
+type Batch struct {
+	Notifier     string
+	Notification registry.Notification
+	Entries      []Entry
+}
+
+//Recipients returns the recipients of all entries in the batch
+func (b Batch) Recipients() []string {
+	recipients := make([]string, 0, len(b.Entries))
+	for _, entry := range b.Entries {
+		recipients = append(recipients, entry.Recipient)
+	}
+	return recipients
+}
+
+//Batches groups entries by notifier and notification (see NotificationID), keeping their order
+func Batches(entries []Entry) []Batch {
+	var batches []Batch
+	index := make(map[string]int)
+	for _, entry := range entries {
+		key := entry.Notifier + "\x00" + entry.NotificationID
+		i, ok := index[key]
+		if !ok {
+			i = len(batches)
+			index[key] = i
+			batches = append(batches, Batch{Notifier: entry.Notifier, Notification: entry.Notification})
+		}
+		batches[i].Entries = append(batches[i].Entries, entry)
+	}
+	return batches
+}
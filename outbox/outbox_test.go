@@ -0,0 +1,239 @@
+package outbox
+
+import (
+	"io/ioutil"
+	"notifier/consts"
+	"notifier/registry"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//dlv returns a delivery to recipient failed with err at a time
+func dlv(recipient string, err consts.ERR, at time.Time) registry.Delivery {
+	d := registry.NewDelivery(recipient, err, nil)
+	d.Time = at
+	return d
+}
+
+func TestEnqueue(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	now := time.Now()
+	ntf := registry.Notification{Subject: "build", Message: "failed", Recipients: map[string][]string{"slack": {"C1", "C2", "C3"}}}
+	other := registry.Notification{Subject: "build", Message: "failed again"}
+	hook := dlv("webhook#1 (hooks.slack.com)", consts.REQ_FAIL, now)
+	hook.Key = registry.URLKey("https://hooks.slack.com/services/T/B/X")
+
+	tests := []struct {
+		name   string
+		res    registry.Result
+		ntf    registry.Notification
+		queued int
+		total  int //entries in the outbox after Enqueue
+	}{
+		{
+			name: "temporary errors only",
+			res: registry.Result{Notifier: "sl", Type: "slack", Deliveries: []registry.Delivery{
+				dlv("C1", consts.NIL, now), dlv("C2", consts.RATE_LIMITED, now), dlv("C3", consts.CHL_NOT_FOUND, now),
+			}},
+			ntf:    ntf,
+			queued: 1,
+			total:  1,
+		},
+		{
+			name: "already queued",
+			res: registry.Result{Notifier: "sl", Type: "slack", Deliveries: []registry.Delivery{
+				dlv("C2", consts.REQ_FAIL, now.Add(time.Minute)),
+			}},
+			ntf:    ntf,
+			queued: 0,
+			total:  1,
+		},
+		{
+			name: "other notification",
+			res: registry.Result{Notifier: "sl", Type: "slack", Deliveries: []registry.Delivery{
+				dlv("C2", consts.REQ_FAIL, now.Add(2*time.Minute)),
+			}},
+			ntf:    other,
+			queued: 1,
+			total:  2,
+		},
+		{
+			name:   "webhook with a key",
+			res:    registry.Result{Notifier: "hook", Type: "slackWebhook", Deliveries: []registry.Delivery{hook}},
+			ntf:    ntf,
+			queued: 1,
+			total:  3,
+		},
+		{
+			name:   "nothing failed",
+			res:    registry.Result{Notifier: "sl", Type: "slack", Deliveries: []registry.Delivery{dlv("C1", consts.NIL, now)}},
+			ntf:    other,
+			queued: 0,
+			total:  3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queued, err := Enqueue(tt.res, tt.ntf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if queued != tt.queued {
+				t.Errorf("Enqueue queued %d entries, want %d", queued, tt.queued)
+			}
+			entries, err := Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != tt.total {
+				t.Errorf("outbox has %d entries, want %d", len(entries), tt.total)
+			}
+		})
+	}
+
+	entries, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the entries are loaded the oldest first, with their notifications
+	first := entries[0]
+	if first.Recipient != "C2" || first.Err != consts.RATE_LIMITED || first.Notification.Message != "failed" {
+		t.Errorf("first entry %+v, want the rate limited delivery to C2", first)
+	}
+	if len(first.Notification.Recipients["slack"]) != 3 {
+		t.Errorf("the notification of an entry lost its recipients: %+v", first.Notification)
+	}
+	for _, entry := range entries {
+		if entry.Notifier == "hook" && entry.RecipientKey != hook.Key {
+			t.Errorf("webhook entry has key %q, want %q", entry.RecipientKey, hook.Key)
+		}
+	}
+	//the notification shared by two entries is stored once
+	files, err := ioutil.ReadDir(notificationsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("%d notifications stored, want 2", len(files))
+	}
+}
+
+func TestRemove(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	now := time.Now()
+	ntf := registry.Notification{Subject: "s", Message: "m"}
+	res := registry.Result{Notifier: "sl", Type: "slack", Deliveries: []registry.Delivery{
+		dlv("C1", consts.REQ_FAIL, now), dlv("C2", consts.REQ_FAIL, now),
+	}}
+	if _, err := Enqueue(res, ntf); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := Load()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Load = %d entries (%v), want 2", len(entries), err)
+	}
+	ntfPath := notificationPath(entries[0].NotificationID)
+
+	tests := []struct {
+		entry     Entry
+		ntfStored bool //the notification is still referred to by an entry
+	}{
+		{entries[0], true},
+		{entries[1], false},
+	}
+	for _, tt := range tests {
+		if err := Remove(tt.entry); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path(tt.entry.ID)); !os.IsNotExist(err) {
+			t.Errorf("entry of %s not removed", tt.entry.Recipient)
+		}
+		if _, err := os.Stat(ntfPath); (err == nil) != tt.ntfStored {
+			t.Errorf("after removing %s, notification stored: %v, want %v", tt.entry.Recipient, err == nil, tt.ntfStored)
+		}
+	}
+}
+
+func TestLoadSkipsBrokenFiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if _, err := Enqueue(registry.Result{Notifier: "sl", Deliveries: []registry.Delivery{dlv("C1", consts.REQ_FAIL, time.Now())}},
+		registry.Notification{Message: "m"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file, content string
+	}{
+		{"broken.json", "{not json"},
+		{"orphan.json", `{"ID": "orphan", "Notifier": "sl", "Recipient": "C9", "NotificationID": "missing"}`},
+		{"notes.txt", "not an entry"},
+	}
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(filepath.Join(Dir(), tt.file), []byte(tt.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Recipient != "C1" {
+		t.Errorf("Load = %+v, want the entry of C1 only", entries)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	entries := []Entry{
+		{Notifier: "sl", NotificationID: "n1", Recipient: "C1"},
+		{Notifier: "mail", NotificationID: "n1", Recipient: "a@b.com"},
+		{Notifier: "sl", NotificationID: "n2", Recipient: "C1"},
+		{Notifier: "sl", NotificationID: "n1", Recipient: "C2"},
+	}
+	want := []struct {
+		notifier   string
+		recipients []string
+	}{
+		{"sl", []string{"C1", "C2"}},
+		{"mail", []string{"a@b.com"}},
+		{"sl", []string{"C1"}},
+	}
+	batches := Batches(entries)
+	if len(batches) != len(want) {
+		t.Fatalf("Batches = %d batches, want %d", len(batches), len(want))
+	}
+	for i, b := range batches {
+		got := b.Recipients()
+		if b.Notifier != want[i].notifier || len(got) != len(want[i].recipients) {
+			t.Errorf("batch %d = %s %v, want %s %v", i, b.Notifier, got, want[i].notifier, want[i].recipients)
+			continue
+		}
+		for j := range got {
+			if got[j] != want[i].recipients[j] {
+				t.Errorf("batch %d = %v, want %v", i, got, want[i].recipients)
+			}
+		}
+	}
+}
+
+func TestEntryID(t *testing.T) {
+	base := entryID("sl", "C1", "", "n1")
+	tests := []struct {
+		name                            string
+		notifier, recipient, key, ntfID string
+		same                            bool
+	}{
+		{"same", "sl", "C1", "", "n1", true},
+		{"other notifier", "sl2", "C1", "", "n1", false},
+		{"other recipient", "sl", "C2", "", "n1", false},
+		{"other notification", "sl", "C1", "", "n2", false},
+		{"with a key", "sl", "C1", "abc", "n1", false},
+		//the fields are separated, so that they cannot be shifted
+		{"shifted", "sl", "", "", "C1n1", false},
+	}
+	for _, tt := range tests {
+		if got := entryID(tt.notifier, tt.recipient, tt.key, tt.ntfID); (got == base) != tt.same {
+			t.Errorf("%s: entryID = %s, same as %s: %v, want %v", tt.name, got, base, got == base, tt.same)
+		}
+	}
+}
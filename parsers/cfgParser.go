@@ -1,28 +1,109 @@
 package parsers
 
 import (
+	"crypto/tls"
+	"errors"
 	"io/ioutil"
 	"log"
+	"net/mail"
+	"net/url"
 	"notifier/consts"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 /*------structs corresponding to the config file for different notifiers------*/
 
-//NotifConfig is the most initial struct(class) corresponding to config-file for notifiers
-type NotifConfig struct {
-	Notifiers Notifiers `yaml:"notifiers"`
+//Notifiers contains all the notifiers parsed from config file, keyed by their names
+//(e.g. "smtpemailnotifier", "slacknotifier")
+type Notifiers map[string]NotifierConfig
+
+//Names returns the names of all the notifiers in alphabetical order
+func (ntfs Notifiers) Names() []string {
+	names := make([]string, 0, len(ntfs))
+	for name := range ntfs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Select returns the notifiers with the specified names
+//names are case-insensitive, the same as the keys in the config file
+func (ntfs Notifiers) Select(names []string) (Notifiers, consts.ERR) {
+	selected := make(Notifiers)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		cfg, ok := ntfs[name]
+		if !ok {
+			log.Println("no notifier named", name, "in", NotifyrcFile)
+			return Notifiers{}, consts.NTF_NOT_FOUND
+		}
+		selected[name] = cfg
+	}
+	return selected, consts.SUCCESS
+}
+
+//ForLevel returns the notifiers whose minimum level is at most the level of a notification
+//(see consts.Level.Routing), the others are logged and left out
+func (ntfs Notifiers) ForLevel(level consts.Level) Notifiers {
+	selected := make(Notifiers)
+	for name, cfg := range ntfs {
+		if cfg.MinLevel > level.Routing() {
+			if cfg.State {
+				log.Println("notifier", name, "skipped: the level", level.Routing(), "is below its minLevel", cfg.MinLevel)
+			}
+			continue
+		}
+		selected[name] = cfg
+	}
+	return selected
 }
 
-//Notifiers is the struct that contains all the possible notifiers parsed from config file
-//After modifying the notifier-config-file, also please add the new notifier here
-//e.g. AWSNotifier AWSNotifier `yaml:"awsnotifier"`
-type Notifiers struct {
-	SMTPEmailNotifier SmtpEmailNotifier `yaml:"smtpemailnotifier"`
-	SlackNotifier     SlackNotifier     `yaml:"slacknotifier"`
+//NotifierConfig is the settings of a single notifier in the config file
+//only "type" and "state" are parsed here, since they are common to all notifiers
+//the type-specific settings can be parsed into the corresponding struct with Decode
+type NotifierConfig struct {
+	Name     string
+	Type     string
+	State    bool
+	Retry    RetryPolicy
+	MinLevel consts.Level //notifications of a lower level are not sent with this notifier
+
+	sub *viper.Viper
+}
+
+//RetryPolicy is the struct corresponding to the yaml:retry of a notifier in the config file
+//deliveries failed with a temporary error are retried with an exponential backoff
+//BaseDelay, 2*BaseDelay, 4*BaseDelay... (at most MaxDelay, or the Retry-After of the server)
+type RetryPolicy struct {
+	MaxAttempts int           `yaml:"maxAttempts"` //including the first attempt, 1 means no retry
+	BaseDelay   time.Duration `yaml:"baseDelay"`   //e.g. 2s
+	MaxDelay    time.Duration `yaml:"maxDelay"`    //e.g. 1m
+	Jitter      float64       `yaml:"jitter"`      //randomize each delay by up to this fraction(0~1)
+}
+
+//commonSettings is the struct of the settings common to all notifiers in the config file
+type commonSettings struct {
+	Type     string      `yaml:"type"`
+	State    bool        `yaml:"state"`
+	Retry    RetryPolicy `yaml:"retry"`
+	MinLevel string      `yaml:"minLevel"` //see consts.ParseLevel
+}
+
+//Decode unmarshalls the whole settings of the notifier into v
+//e.g. a *SmtpEmailNotifier for a notifier of type "smtpemail"
+func (cfg NotifierConfig) Decode(v interface{}) error {
+	if cfg.sub == nil {
+		return nil
+	}
+	return cfg.sub.Unmarshal(v)
 }
 
 //SmtpEmailNotifier is the struct corresponding to the yaml:smtpemailnotifier in the config file
@@ -33,6 +114,34 @@ type SmtpEmailNotifier struct {
 	Pwd      string `yaml:"pwd"`
 	SMTPHost string `yaml:"SMTPHost"`
 	SMTPPort string `yaml:"SMTPPort"`
+	TLSMode  string `yaml:"tlsMode"` //implicit(default), starttls or none
+	//plain(default if pwd is set), login, cram-md5, xoauth2 or none(default if pwd is not set)
+	Auth string `yaml:"auth"`
+	//the OAuth2 access token of xoauth2 is read from TokenFile, or else from the output of TokenCmd
+	TokenFile string `yaml:"tokenFile"`
+	TokenCmd  string `yaml:"tokenCmd"`
+	//the server certificate is verified unless InsecureSkipVerify is set
+	CAFile             string `yaml:"caFile"`        //PEM CA bundle used instead of the system CAs
+	CertFile           string `yaml:"certFile"`      //PEM client certificate, for mTLS relays
+	KeyFile            string `yaml:"keyFile"`       //PEM key of the client certificate
+	MinTLSVersion      string `yaml:"minTLSVersion"` //1.0, 1.1, 1.2(default) or 1.3, see ParseTLSVersion
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+//tlsVersions are the values of "minTLSVersion" in the config file
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//ParseTLSVersion parses a minTLSVersion: 1.0, 1.1, 1.2 or 1.3, optionally prefixed with "TLS" (e.g. TLS1.2, tlsv1.3)
+//returns false if the version is unknown
+func ParseTLSVersion(version string) (uint16, bool) {
+	version = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(version)), "tls")
+	tlsVersion, ok := tlsVersions[strings.TrimPrefix(version, "v")]
+	return tlsVersion, ok
 }
 
 //SlackNotifier is the struct corresponding to the yaml:slacknotifier in the config file
@@ -43,25 +152,44 @@ type SlackNotifier struct {
 	AsUser      bool     `yaml:"asUser"`
 	UserName    string   `yaml:"userName"`
 	IconEmoji   string   `yaml:"iconEmoji"`
+	IconURL     string   `yaml:"iconURL"`
 	WebhookURLs []string `yaml:"WebhookURLs"`
+	Color       string   `yaml:"color"`       //color of the message attachment: good, warning, danger or a hex code such as #439FE0
+	Footer      string   `yaml:"footer"`      //footer of the message attachment, e.g. the host name
+	UnfurlLinks *bool    `yaml:"unfurlLinks"` //unset leaves the default of slack
+	UnfurlMedia *bool    `yaml:"unfurlMedia"`
 }
 
-//Add new Notifier struct here:
+//WebhookNotifier is the struct corresponding to a notifier of type "webhook" in the config file
+type WebhookNotifier struct {
+	Type         string            `yaml:"type"`
+	State        bool              `yaml:"state"`
+	URL          string            `yaml:"url"`
+	Method       string            `yaml:"method"`
+	Headers      map[string]string `yaml:"headers"`
+	Body         string            `yaml:"body"`
+	SuccessCodes []int             `yaml:"successCodes"`
+}
+
+//Add new Notifier struct here and decode it with NotifierConfig.Decode:
 //e.g. type AWSNotifier struct {}
 
 /*------please add new Notifiers above this line------*/
 
 //initViper initializes a viper for yaml parsing
-//specify the target *.yaml file to "file" parameter
-//return a viper instance(reference type)
-func initViper(file string) *viper.Viper {
+//specify the target *.yaml file to "file" parameter, a config name or a path (see FindConfig)
+//the keys read are overridden by the environment variables with applyEnvOverrides
+//return a viper instance(reference type), or an error if the file is not found
+func initViper(file string) (*viper.Viper, error) {
+	path, err := FindConfig(file)
+	if err != nil {
+		return nil, err
+	}
 	//initialize an viper for notifiers
 	nviper := viper.New()
-	//name of config file(without extension)
-	nviper.SetConfigName(file)
-	//paths to look for notifyrc file in
-	nviper.AddConfigPath("$HOME")
-	nviper.AddConfigPath(".")
+	nviper.SetConfigFile(path)
+	//yaml even if the file has no extension
+	nviper.SetConfigType("yaml")
 
 	//tell the viper instance to watchConfig
 	nviper.WatchConfig()
@@ -70,30 +198,81 @@ func initViper(file string) *viper.Viper {
 		func(e fsnotify.Event) {
 			log.Println("config file changed:", e.Name)
 		})
-	return nviper
+	return nviper, nil
 }
 
 //parse notifiers objects from the *.yaml file specified by "file"
 //using viper
-//return a Notifiers struct
+//the secrets (see SecretKeys) of the notifiers whose state is on are resolved and registered for redaction
+//return a Notifiers map
 func ParseNotifiers(file string) (Notifiers, consts.ERR) {
 	//initialize viper to parse notifyrcFile
-	nviper := initViper(consts.NotifyrcFile)
-	//find and read notifyrc file
-	err := nviper.ReadInConfig()
+	nviper, err := initViper(file)
 	if err != nil {
 		log.Println(err)
 		return Notifiers{}, consts.NOTIFRC_PARSE_ERR
 	}
-
-	//initialize Notifiers
-	var ntfCfg NotifConfig
-	//unmarshall into Notifiers
-	if err := nviper.Unmarshal(&ntfCfg); err != nil {
+	//find and read notifyrc file
+	if err = nviper.ReadInConfig(); err != nil {
 		log.Println(err)
 		return Notifiers{}, consts.NOTIFRC_PARSE_ERR
 	}
-	return ntfCfg.Notifiers, consts.SUCCESS
+	log.Println("notifiers loaded from", nviper.ConfigFileUsed())
+
+	//the notifiers whose names have the same environment variables are not overridden
+	var names []string
+	for name := range nviper.GetStringMap("notifiers") {
+		names = append(names, "notifiers."+name)
+	}
+	conflicts := envConflicts(names)
+
+	ntfs := make(Notifiers)
+	for name := range nviper.GetStringMap("notifiers") {
+		sub := nviper.Sub("notifiers." + name)
+		if sub == nil {
+			log.Println("notifier", name, "has no settings, ignored")
+			continue
+		}
+		//the environment variables override the config file
+		if !conflicts["notifiers."+name] {
+			applyEnvOverrides(sub, "notifiers."+name+".", notifierSettings...)
+		}
+		//parse the settings common to all notifiers
+		var common commonSettings
+		if err := sub.Unmarshal(&common); err != nil {
+			log.Println(err)
+			return Notifiers{}, consts.NOTIFRC_PARSE_ERR
+		}
+		minLevel, ok := consts.ParseLevel(common.MinLevel)
+		if !ok {
+			log.Println("unknown minLevel \""+common.MinLevel+"\" of notifier", name, "("+strings.Join(consts.LevelNames(), ", ")+")")
+			return Notifiers{}, consts.NOTIFRC_PARSE_ERR
+		}
+		//resolve the secrets of the notifiers in use only
+		//so that a disabled notifier never runs a command or needs an environment variable
+		if common.State {
+			for _, key := range SecretKeys {
+				if !sub.IsSet(key) {
+					continue
+				}
+				secret, err := resolveSecrets(sub.Get(key))
+				if err != nil {
+					log.Println("cannot resolve", key, "of notifier", name, ":", err)
+					return Notifiers{}, consts.NOTIFRC_PARSE_ERR
+				}
+				sub.Set(key, secret)
+			}
+		}
+		ntfs[name] = NotifierConfig{
+			Name:     name,
+			Type:     common.Type,
+			State:    common.State,
+			Retry:    common.Retry,
+			MinLevel: minLevel,
+			sub:      sub,
+		}
+	}
+	return ntfs, consts.SUCCESS
 }
 
 //DfltConfig is the most initial struct(class) corresponding to config-file for default settings
@@ -104,24 +283,33 @@ type DfltConfig struct {
 //Defaults contains all the default settings stored in the defaultsFile
 //If you modify the defaultsFile, please also modify this struct correspondingly
 type Defaults struct {
-	EmailListFile string `yaml:"emailListFile"`
-	SlackListFile string `yaml:"slackListFile"`
-	Subject       string `yaml:"subject"`
-	Message       string `yaml:"message"`
-	MessageFile   string `yaml:"messageFile"`
+	EmailListFile string   `yaml:"emailListFile"`
+	SlackListFile string   `yaml:"slackListFile"`
+	Subject       string   `yaml:"subject"`
+	Message       string   `yaml:"message"`
+	MessageFile   string   `yaml:"messageFile"`
+	Cc            []string `yaml:"cc"`
+	Bcc           []string `yaml:"bcc"`
+	ReplyTo       []string `yaml:"replyTo"`
 }
 
 //parse the Defaults object from *.yaml file
 //return a Defaults struct
 func ParseDefaults(file string) (Defaults, consts.ERR) {
-	dviper := initViper(consts.DefaultsFile)
+	dviper, err := initViper(file)
+	if err != nil {
+		log.Println(err)
+		return Defaults{}, consts.DFLTS_PARSE_ERR
+	}
 
 	//find and read defaults file
-	err := dviper.ReadInConfig()
-	if err != nil {
+	if err = dviper.ReadInConfig(); err != nil {
 		log.Println(err)
 		return Defaults{}, consts.DFLTS_PARSE_ERR
 	}
+	log.Println("defaults loaded from", dviper.ConfigFileUsed())
+	//the environment variables override the config file
+	applyEnvOverrides(dviper, "", DfltConfig{})
 
 	//initialize Defaults object
 	var dfltCfg DfltConfig
@@ -171,42 +359,68 @@ func (dflt *Defaults) GetDfltEmailList() []string {
 	return []string{}
 }
 
+//GetDfltCc returns default email addrs to be carbon copied set by the defaultsFile
+func (dflt *Defaults) GetDfltCc() []string {
+	return dflt.Cc
+}
+
+//GetDfltBcc returns default email addrs to be blind carbon copied set by the defaultsFile
+func (dflt *Defaults) GetDfltBcc() []string {
+	return dflt.Bcc
+}
+
+//GetDfltReplyTo returns default Reply-To email addrs set by the defaultsFile
+func (dflt *Defaults) GetDfltReplyTo() []string {
+	return dflt.ReplyTo
+}
+
 /*------ these methods of Defaults struct above will be called only if the input message is "" ------*/
 
-//cfgRead gets value of a specific item in cfgFile
-//and return it as an interface{}
-func cfgRead(item, cfgFile string) (interface{}, error) {
-	viper.SetConfigName(cfgFile)
-	viper.AddConfigPath("$HOME")
-	viper.AddConfigPath(".")
-	if err := viper.ReadInConfig(); err != nil {
+//cfgRead gets the yaml node of a specific item in cfgFile
+//and return it, or nil if the item is not in cfgFile
+func cfgRead(item, cfgFile string) (*yaml.Node, error) {
+	path, err := FindConfig(cfgFile)
+	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-	return viper.Get(item), nil
+	doc, err := loadYAML(path)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return lookupYAML(doc, item), nil
 }
 
 //cfgWrite overwrites a specified item to newVal in cfgFile
+//the file is edited as a yaml node tree, so that its comments, key order and document markers are kept
+//the previous version is kept as a backup (see saveYAML)
 func cfgWrite(item string, newVal interface{}, cfgFile string) error {
-	viper.SetConfigName(cfgFile)
-	viper.AddConfigPath("$HOME")
-	viper.AddConfigPath(".")
-	if err := viper.ReadInConfig(); err != nil {
+	path, err := FindConfig(cfgFile)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	doc, err := loadYAML(path)
+	if err != nil {
 		log.Println(err)
 		return err
 	}
-	viper.Set(item, newVal)
-	return viper.WriteConfig()
+	if err := setYAML(doc, item, newVal); err != nil {
+		log.Println(err)
+		return err
+	}
+	return saveYAML(path, doc)
 }
 
 //CfgDflt overwrites a specified item to newVal in defaultsFile
 func CfgDflt(item string, newVal interface{}) error {
-	return cfgWrite(item, newVal, consts.DefaultsFile)
+	return cfgWrite(item, newVal, DefaultsFile)
 }
 
 //CfgNtfyrc overwrites a specified item to newVal in notifyrc.yml
 func CfgNtfyrc(item string, newVal interface{}) error {
-	return cfgWrite(item, newVal, consts.NotifyrcFile)
+	return cfgWrite(item, newVal, NotifyrcFile)
 }
 
 //CfgDfltSbjt overwrites default subject in defaultsFile
@@ -254,18 +468,200 @@ func CfgDfltEmailListFile(newFile string) error {
 	return err
 }
 
-//CfgToggStat toggles state between on and off for a specific notifier type
-//it will modify notifyrc.yml
-func CfgToggStat(ntfName string) error {
-	item := "notifiers." + ntfName + ".state"
-	state, err := cfgRead(item, consts.NotifyrcFile)
+//CfgToggStat toggles state between on and off for a specific notifier
+//ntfName is the name of the notifier in notifyrc.yml (e.g. smtpemailnotifier)
+//it will modify notifyrc.yml, and returns NTF_NOT_FOUND if there is no such notifier,
+//NOTIFRC_PARSE_ERR if notifyrc.yml or the state cannot be parsed, GENERAL_ERR if the file cannot be written
+func CfgToggStat(ntfName string) consts.ERR {
+	path, err := FindConfig(NotifyrcFile)
+	if err != nil {
+		log.Println(err)
+		return consts.NOTIFRC_PARSE_ERR
+	}
+	doc, err := loadYAML(path)
 	if err != nil {
 		log.Println(err)
+		return consts.NOTIFRC_PARSE_ERR
+	}
+	if lookupYAML(doc, "notifiers."+ntfName) == nil {
+		log.Println("no notifier named", ntfName, "in", NotifyrcFile)
+		return consts.NTF_NOT_FOUND
+	}
+	item := "notifiers." + ntfName + ".state"
+	state := lookupYAML(doc, item)
+	if state == nil {
+		log.Println("notifier", ntfName, "has no state in", NotifyrcFile)
+		return consts.NOTIFRC_PARSE_ERR
+	}
+	curState, ok := parseBool(state.Value)
+	if !ok || state.Kind != yaml.ScalarNode {
+		log.Println("state of " + ntfName + " is \"" + state.Value + "\", not on/off (or true/false)")
+		return consts.NOTIFRC_PARSE_ERR
+	}
+	if err := setYAML(doc, item, !curState); err != nil {
+		log.Println(err)
+		return consts.NOTIFRC_PARSE_ERR
+	}
+	if err := saveYAML(path, doc); err != nil {
+		log.Println(err)
+		return consts.GENERAL_ERR
+	}
+	log.Println("toggle state of ", ntfName, " to ", !curState)
+	return consts.NIL
+}
+
+//cfgNtfType returns the type of a notifier in notifyrc.yml in lower case
+//returns an error if there is no such notifier, or it is not one of the types
+func cfgNtfType(ntfName string, types ...string) (string, error) {
+	typ, err := cfgRead("notifiers."+ntfName+".type", NotifyrcFile)
+	if err != nil {
+		return "", err
+	}
+	if typ == nil || typ.Kind != yaml.ScalarNode {
+		return "", errors.New("no notifier named " + ntfName + " with a type in " + NotifyrcFile)
+	}
+	typStr := typ.Value
+	for _, t := range types {
+		if strings.EqualFold(t, typStr) {
+			return strings.ToLower(typStr), nil
+		}
+	}
+	return "", errors.New("notifier " + ntfName + " is of type " + typStr + ", not " + strings.Join(types, " or "))
+}
+
+//cfgNtfSet overwrites a setting of a notifier of one of the types in notifyrc.yml
+//the value is logged unless it is a secret
+func cfgNtfSet(ntfName, key string, newVal interface{}, secret bool, types ...string) error {
+	if _, err := cfgNtfType(ntfName, types...); err != nil {
 		return err
 	}
-	err = CfgNtfyrc(item, !state.(bool))
-	if err == nil {
-		log.Println("toggle state of ", ntfName, " to ", !state.(bool))
+	err := CfgNtfyrc("notifiers."+ntfName+"."+key, newVal)
+	if err == nil && secret {
+		log.Println(key, "of", ntfName, "reset")
+	} else if err == nil {
+		log.Println(key, "of", ntfName, "reset as:", newVal)
 	}
 	return err
 }
+
+//CfgEmailHost overwrites the SMTP host of an smtpemail notifier in notifyrc.yml
+func CfgEmailHost(ntfName, host string) error {
+	if host == "" || strings.ContainsAny(host, " /:") {
+		return errors.New("invalid SMTP host \"" + host + "\"")
+	}
+	return cfgNtfSet(ntfName, "SMTPHost", host, false, consts.SMTPEmailType)
+}
+
+//CfgEmailPort overwrites the SMTP port of an smtpemail notifier in notifyrc.yml
+func CfgEmailPort(ntfName, port string) error {
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return errors.New("invalid SMTP port \"" + port + "\" (1-65535)")
+	}
+	return cfgNtfSet(ntfName, "SMTPPort", p, false, consts.SMTPEmailType)
+}
+
+//CfgEmailAccount overwrites the sender account of an smtpemail notifier in notifyrc.yml
+func CfgEmailAccount(ntfName, account string) error {
+	if _, err := mail.ParseAddress(account); err != nil {
+		return errors.New("invalid email address \"" + account + "\"")
+	}
+	return cfgNtfSet(ntfName, "account", account, false, consts.SMTPEmailType)
+}
+
+//CfgEmailPwd overwrites the password of an smtpemail notifier in notifyrc.yml
+//pwd can refer to a secret stored elsewhere (see ResolveSecret), e.g. env:SMTP_PASS
+func CfgEmailPwd(ntfName, pwd string) error {
+	return cfgNtfSet(ntfName, "pwd", pwd, true, consts.SMTPEmailType)
+}
+
+//CfgSlackToken overwrites the token of a slack notifier in notifyrc.yml
+//token can refer to a secret stored elsewhere (see ResolveSecret), e.g. file:/run/secrets/slack
+func CfgSlackToken(ntfName, token string) error {
+	if token == "" {
+		return errors.New("empty slack token")
+	}
+	return cfgNtfSet(ntfName, "token", token, true, consts.SlackType, consts.SlackWebhookType)
+}
+
+//checkWebhookURL checks an absolute http(s) url, or a reference to a secret url (see ResolveSecret)
+//the url is not part of the error, since it is a secret
+func checkWebhookURL(webhookURL string) error {
+	if IsSecretRef(webhookURL) {
+		return nil
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("invalid webhook url, an http(s) url or a secret (e.g. env:SLACK_WEBHOOK) is needed")
+	}
+	return nil
+}
+
+//CfgSlackWebhooks adds or removes a webhook url of a slack notifier in notifyrc.yml
+//webhookURL can refer to a secret stored elsewhere (see ResolveSecret), e.g. env:SLACK_WEBHOOK
+func CfgSlackWebhooks(ntfName, webhookURL string, remove bool) error {
+	if err := checkWebhookURL(webhookURL); err != nil {
+		return err
+	}
+	if _, err := cfgNtfType(ntfName, consts.SlackType, consts.SlackWebhookType); err != nil {
+		return err
+	}
+	item := "notifiers." + ntfName + ".WebhookURLs"
+	cur, err := cfgRead(item, NotifyrcFile)
+	if err != nil {
+		return err
+	}
+	var urls []string
+	found := false
+	for _, u := range webhookURLs(cur) {
+		if u == webhookURL {
+			found = true
+			if remove {
+				continue
+			}
+		}
+		urls = append(urls, u)
+	}
+	switch {
+	case remove && !found:
+		return errors.New("no such webhook url in notifier " + ntfName)
+	case !remove && found:
+		log.Println("webhook url already in", ntfName)
+		return nil
+	case !remove:
+		urls = append(urls, webhookURL)
+	}
+	if err := CfgNtfyrc(item, urls); err != nil {
+		return err
+	}
+	if remove {
+		log.Println("webhook url removed from", ntfName)
+	} else {
+		log.Println("webhook url added to", ntfName)
+	}
+	return nil
+}
+
+//webhookURLs returns the urls in the yaml node of WebhookURLs (a list, or a single url)
+func webhookURLs(node *yaml.Node) []string {
+	var urls []string
+	switch {
+	case node == nil:
+	case node.Kind == yaml.SequenceNode:
+		for _, item := range node.Content {
+			urls = append(urls, item.Value)
+		}
+	case node.Kind == yaml.ScalarNode && node.Value != "":
+		urls = append(urls, node.Value)
+	}
+	return urls
+}
+
+//CfgWebhookURL overwrites the url of a webhook notifier in notifyrc.yml
+//webhookURL can refer to a secret stored elsewhere (see ResolveSecret), e.g. env:ALERT_WEBHOOK
+func CfgWebhookURL(ntfName, webhookURL string) error {
+	if err := checkWebhookURL(webhookURL); err != nil {
+		return err
+	}
+	return cfgNtfSet(ntfName, "url", webhookURL, true, consts.WebhookType)
+}
@@ -0,0 +1,63 @@
+package parsers
+
+import (
+	"errors"
+	"notifier/consts"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//NotifyrcFile and DefaultsFile are the config files read and written by this package
+//either a config name searched for by FindConfig (the default), or a path (e.g. set by the --config and --defaults flags)
+var (
+	NotifyrcFile = consts.NotifyrcFile
+	DefaultsFile = consts.DefaultsFile
+)
+
+//configExts are the extensions of the config files searched for by FindConfig, in order
+var configExts = []string{"yml", "yaml"}
+
+//configDirs returns the directories FindConfig searches in, in order:
+//$XDG_CONFIG_HOME/notifier ($HOME/.config/notifier by default), $HOME and the current directory
+func configDirs() []string {
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		xdg = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return []string{filepath.Join(xdg, "notifier"), os.Getenv("HOME"), "."}
+}
+
+//isConfigName reports whether file is a config name such as ".notifyrc" rather than a path
+func isConfigName(file string) bool {
+	return !strings.ContainsRune(file, os.PathSeparator) && filepath.Ext(strings.TrimPrefix(file, ".")) == ""
+}
+
+//FindConfig returns the path of a config file
+//a path is returned as it is if the file exists
+//a config name (e.g. ".notifyrc") is searched for in configDirs with the extensions .yml and .yaml
+//the leading dot is optional in $XDG_CONFIG_HOME/notifier (e.g. notifyrc.yml)
+func FindConfig(file string) (string, error) {
+	if !isConfigName(file) {
+		if _, err := os.Stat(file); err != nil {
+			return "", err
+		}
+		return file, nil
+	}
+	dirs := configDirs()
+	for i, dir := range dirs {
+		names := []string{file}
+		if i == 0 {
+			names = append(names, strings.TrimPrefix(file, "."))
+		}
+		for _, name := range names {
+			for _, ext := range configExts {
+				path := filepath.Join(dir, name+"."+ext)
+				if info, err := os.Stat(path); err == nil && !info.IsDir() {
+					return path, nil
+				}
+			}
+		}
+	}
+	return "", errors.New("config file " + file + ".yml not found in " + strings.Join(dirs, ", "))
+}
@@ -0,0 +1,139 @@
+package parsers
+
+import (
+	"log"
+	"notifier/consts"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+//EnvName returns the environment variable overriding a config key
+//consts.EnvPrefix, "_" and the key in upper case, with every character other than letters and digits replaced by "_"
+//e.g. NOTIFIER_NOTIFIERS_SLACKNOTIFIER_STATE for "notifiers.slacknotifier.state"
+//and NOTIFIER_NOTIFIERS_GMAIL_FALLBACK_PWD for "notifiers.gmail-fallback.pwd"
+func EnvName(key string) string {
+	name := []rune(strings.ToUpper(key))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return consts.EnvPrefix + "_" + string(name)
+}
+
+//notifierSettings are the structs of the settings of the notifiers, whose keys can be set by the environment variables
+//even if they are not in the config file (see applyEnvOverrides)
+//please add the struct of a new Notifier here too
+var notifierSettings = []interface{}{
+	commonSettings{},
+	SmtpEmailNotifier{},
+	SlackNotifier{},
+	WebhookNotifier{},
+}
+
+//settingKeys returns the keys of the settings struct v (lower case, as the keys of viper) named by their yaml tags
+//with the kind of their values, the keys of a nested struct are prefixed with the key of the struct (e.g. "retry.maxattempts")
+func settingKeys(v interface{}) map[string]reflect.Kind {
+	keys := make(map[string]reflect.Kind)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := strings.ToLower(strings.Split(f.Tag.Get("yaml"), ",")[0])
+		if key == "" || key == "-" {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct {
+			for sub, kind := range settingKeys(reflect.Zero(ft).Interface()) {
+				keys[key+"."+sub] = kind
+			}
+			continue
+		}
+		keys[key] = ft.Kind()
+	}
+	return keys
+}
+
+//envConflicts returns the keys whose environment variable (see EnvName) is the one of another key
+//e.g. "notifiers.gmail-fallback" and "notifiers.gmail_fallback", which cannot be told apart by the variables
+//a conflict is logged if the variable is set, or if a variable of the keys below is set (e.g. NOTIFIER_NOTIFIERS_GMAIL_FALLBACK_PWD)
+func envConflicts(keys []string) map[string]bool {
+	byEnv := make(map[string][]string)
+	for _, key := range keys {
+		env := EnvName(key)
+		byEnv[env] = append(byEnv[env], key)
+	}
+	conflicts := make(map[string]bool)
+	for env, same := range byEnv {
+		if len(same) < 2 {
+			continue
+		}
+		for _, key := range same {
+			conflicts[key] = true
+		}
+		if !envSet(env) {
+			continue
+		}
+		sort.Strings(same)
+		log.Println(strings.Join(same, " and "), "share the environment variables", env+"..., which do not override them")
+	}
+	return conflicts
+}
+
+//envSet reports whether the environment variable env, or a variable whose name starts with env+"_", is set
+func envSet(env string) bool {
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if name == env || strings.HasPrefix(name, env+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+//applyEnvOverrides overrides the keys of v with the environment variables named by EnvName
+//keyPrefix is the path of v in the config file (e.g. "notifiers.slacknotifier." for a notifier parsed with Sub)
+//the keys are the ones in the config file and the ones of the structs settings, so that a key missing from the file can be set too
+//a value replacing a boolean may be on/off or yes/no as well as in the config file
+func applyEnvOverrides(v *viper.Viper, keyPrefix string, settings ...interface{}) {
+	kinds := make(map[string]reflect.Kind)
+	for _, s := range settings {
+		for key, kind := range settingKeys(s) {
+			kinds[key] = kind
+		}
+	}
+	for _, key := range v.AllKeys() {
+		if _, ok := kinds[key]; !ok {
+			kinds[key] = reflect.ValueOf(v.Get(key)).Kind()
+		}
+	}
+	keys := make([]string, 0, len(kinds))
+	for key := range kinds {
+		keys = append(keys, keyPrefix+key)
+	}
+	sort.Strings(keys)
+	conflicts := envConflicts(keys)
+	for _, key := range keys {
+		env := EnvName(key)
+		val, ok := os.LookupEnv(env)
+		if !ok || conflicts[key] {
+			continue
+		}
+		log.Println(key, "overridden by", env)
+		key = strings.TrimPrefix(key, keyPrefix)
+		if kinds[key] == reflect.Bool {
+			if b, ok := parseBool(val); ok {
+				v.Set(key, b)
+				continue
+			}
+		}
+		v.Set(key, val)
+	}
+}
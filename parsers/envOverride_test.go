@@ -0,0 +1,168 @@
+package parsers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"notifiers.slacknotifier.state", "NOTIFIER_NOTIFIERS_SLACKNOTIFIER_STATE"},
+		{"notifiers.gmail-fallback.pwd", "NOTIFIER_NOTIFIERS_GMAIL_FALLBACK_PWD"},
+		{"notifiers.gmail_fallback.pwd", "NOTIFIER_NOTIFIERS_GMAIL_FALLBACK_PWD"},
+		{"notifiers.SMTPEmail.SMTPPort", "NOTIFIER_NOTIFIERS_SMTPEMAIL_SMTPPORT"},
+		{"retry.maxattempts", "NOTIFIER_RETRY_MAXATTEMPTS"},
+		{"notifiers.café.url", "NOTIFIER_NOTIFIERS_CAF__URL"},
+	}
+	for _, tt := range tests {
+		if got := EnvName(tt.key); got != tt.want {
+			t.Errorf("EnvName(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestSettingKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings interface{}
+		want     map[string]reflect.Kind
+	}{
+		{"common", commonSettings{}, map[string]reflect.Kind{
+			"type": reflect.String, "state": reflect.Bool, "minlevel": reflect.String,
+			"retry.maxattempts": reflect.Int, "retry.basedelay": reflect.Int64, "retry.maxdelay": reflect.Int64, "retry.jitter": reflect.Float64,
+		}},
+		{"slack", SlackNotifier{}, map[string]reflect.Kind{
+			"token": reflect.String, "webhookurls": reflect.Slice, "asuser": reflect.Bool, "unfurllinks": reflect.Bool,
+		}},
+		{"webhook", WebhookNotifier{}, map[string]reflect.Kind{
+			"url": reflect.String, "headers": reflect.Map,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := settingKeys(tt.settings)
+			for key, kind := range tt.want {
+				if got[key] != kind {
+					t.Errorf("settingKeys has %s of kind %v, want %v", key, got[key], kind)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvConflicts(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		want []string
+	}{
+		{"none", []string{"notifiers.a.pwd", "notifiers.b.pwd"}, nil},
+		{"dash and underscore", []string{"notifiers.gmail-fallback.pwd", "notifiers.gmail_fallback.pwd", "notifiers.gmail.pwd"},
+			[]string{"notifiers.gmail-fallback.pwd", "notifiers.gmail_fallback.pwd"}},
+		{"case", []string{"notifiers.Slack.token", "notifiers.slack.token"}, []string{"notifiers.Slack.token", "notifiers.slack.token"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := envConflicts(tt.keys)
+			if len(got) != len(tt.want) {
+				t.Errorf("envConflicts(%v) = %v, want %v", tt.keys, got, tt.want)
+			}
+			for _, key := range tt.want {
+				if !got[key] {
+					t.Errorf("envConflicts(%v) misses %s", tt.keys, key)
+				}
+			}
+		})
+	}
+}
+
+const envTestConfig = `
+type: slack
+state: on
+token: xoxb-file
+WebhookURLs:
+  - https://hooks.example.com/file
+retry:
+  maxAttempts: 1
+`
+
+func TestApplyEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(t *testing.T, s SlackNotifier, c commonSettings)
+	}{
+		{
+			name: "no override",
+			check: func(t *testing.T, s SlackNotifier, c commonSettings) {
+				if s.Token != "xoxb-file" || !s.State || c.Retry.MaxAttempts != 1 {
+					t.Errorf("settings changed without overrides: %+v %+v", s, c)
+				}
+			},
+		},
+		{
+			name: "string and boolean",
+			env:  map[string]string{"NOTIFIER_NOTIFIERS_SL_TOKEN": "xoxb-env", "NOTIFIER_NOTIFIERS_SL_STATE": "off"},
+			check: func(t *testing.T, s SlackNotifier, c commonSettings) {
+				if s.Token != "xoxb-env" || s.State {
+					t.Errorf("token %q state %v, want xoxb-env false", s.Token, s.State)
+				}
+			},
+		},
+		{
+			name: "key missing from the file",
+			env:  map[string]string{"NOTIFIER_NOTIFIERS_SL_FOOTER": "ci-01", "NOTIFIER_NOTIFIERS_SL_ASUSER": "yes", "NOTIFIER_NOTIFIERS_SL_MINLEVEL": "error"},
+			check: func(t *testing.T, s SlackNotifier, c commonSettings) {
+				if s.Footer != "ci-01" || !s.AsUser || c.MinLevel != "error" {
+					t.Errorf("footer %q asUser %v minLevel %q, want ci-01 true error", s.Footer, s.AsUser, c.MinLevel)
+				}
+			},
+		},
+		{
+			name: "nested key",
+			env:  map[string]string{"NOTIFIER_NOTIFIERS_SL_RETRY_MAXATTEMPTS": "4"},
+			check: func(t *testing.T, s SlackNotifier, c commonSettings) {
+				if c.Retry.MaxAttempts != 4 {
+					t.Errorf("retry.maxAttempts %d, want 4", c.Retry.MaxAttempts)
+				}
+			},
+		},
+		{
+			name: "comma-separated list",
+			env:  map[string]string{"NOTIFIER_NOTIFIERS_SL_WEBHOOKURLS": "https://a.example.com/1,https://b.example.com/2"},
+			check: func(t *testing.T, s SlackNotifier, c commonSettings) {
+				want := []string{"https://a.example.com/1", "https://b.example.com/2"}
+				if !reflect.DeepEqual(s.WebhookURLs, want) {
+					t.Errorf("WebhookURLs %v, want %v", s.WebhookURLs, want)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, val := range tt.env {
+				t.Setenv(name, val)
+			}
+			v := viper.New()
+			v.SetConfigType("yaml")
+			if err := v.ReadConfig(strings.NewReader(envTestConfig)); err != nil {
+				t.Fatal(err)
+			}
+			applyEnvOverrides(v, "notifiers.sl.", notifierSettings...)
+			var s SlackNotifier
+			var c commonSettings
+			if err := v.Unmarshal(&s); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.Unmarshal(&c); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, s, c)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package parsers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+//SecretKeys are the settings of a notifier which hold secrets (e.g. pwd of smtpemail, token of slack)
+//as well as the urls of the webhooks (the url is the credential) and the values of the webhook headers (e.g. Authorization)
+//their values (each item of a list, each value of a mapping) can refer to a secret stored elsewhere:
+//"env:NAME" (environment variable), "file:/path" (file content) or "cmd:command" (output of a shell command)
+//the secrets they refer to are redacted from the log output
+var SecretKeys = []string{"pwd", "token", "url", "WebhookURLs", "headers"}
+
+//redacted replaces a secret in the log output
+const redacted = "******"
+
+//minSecretLen is the minimum length of a secret to redact, shorter values would redact unrelated text
+const minSecretLen = 8
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+//IsSecretRef reports whether a setting value refers to a secret stored elsewhere (see ResolveSecret)
+func IsSecretRef(val string) bool {
+	return strings.HasPrefix(val, "env:") || strings.HasPrefix(val, "file:") || strings.HasPrefix(val, "cmd:")
+}
+
+//ResolveSecret returns the secret that a setting value refers to
+//values without the prefix "env:", "file:" or "cmd:" are returned as they are
+//the trailing newline of a file or a command output is removed
+func ResolveSecret(val string) (string, error) {
+	switch {
+	case strings.HasPrefix(val, "env:"):
+		name := strings.TrimPrefix(val, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.New("environment variable " + name + " is not set")
+		}
+		return secret, nil
+	case strings.HasPrefix(val, "file:"):
+		secret, err := ioutil.ReadFile(strings.TrimPrefix(val, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(secret), "\r\n"), nil
+	case strings.HasPrefix(val, "cmd:"):
+		cmd := strings.TrimPrefix(val, "cmd:")
+		secret, err := exec.Command("sh", "-c", cmd).Output()
+		if err != nil {
+			return "", errors.New("command \"" + cmd + "\" failed: " + err.Error())
+		}
+		return strings.TrimRight(string(secret), "\r\n"), nil
+	}
+	return val, nil
+}
+
+//resolveSecrets resolves the value of a secret setting (see SecretKeys)
+//and registers the secrets that are referred to by "env:", "file:" or "cmd:" for redaction
+//values written in the config file as they are are not redacted (e.g. the placeholder "------")
+//val is a string, a list of strings (e.g. WebhookURLs) or a mapping of strings (e.g. headers)
+func resolveSecrets(val interface{}) (interface{}, error) {
+	switch val := val.(type) {
+	case []interface{}:
+		resolved := make([]string, 0, len(val))
+		for _, item := range val {
+			secret, err := resolveSecrets(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved = append(resolved, secret.(string))
+		}
+		return resolved, nil
+	case map[string]interface{}:
+		resolved := make(map[string]string, len(val))
+		for key, item := range val {
+			secret, err := resolveSecrets(item)
+			if err != nil {
+				return nil, errors.New(key + ": " + err.Error())
+			}
+			resolved[key] = secret.(string)
+		}
+		return resolved, nil
+	}
+	ref := fmt.Sprint(val)
+	secret, err := ResolveSecret(ref)
+	if err != nil {
+		return nil, err
+	}
+	if IsSecretRef(ref) {
+		AddSecret(secret)
+	}
+	return secret, nil
+}
+
+//AddSecret registers a secret to be redacted by Redact
+//e.g. a token obtained while sending a notification
+func AddSecret(secret string) {
+	if len(secret) < minSecretLen {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, s := range secrets {
+		if s == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+}
+
+//Redact replaces all the secrets registered by AddSecret in s
+//only whole tokens are replaced, e.g. an address or a header which merely contains a secret is left alone
+func Redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		s = redactToken(s, secret)
+	}
+	return s
+}
+
+//redactToken replaces each occurrence of secret in s that is not part of a longer token
+func redactToken(s, secret string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, secret)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		end := i + len(secret)
+		if isTokenByte(s, i-1) || isTokenByte(s, end) {
+			//part of a longer token, keep it and search after its first byte
+			b.WriteString(s[:i+1])
+			s = s[i+1:]
+			continue
+		}
+		b.WriteString(s[:i])
+		b.WriteString(redacted)
+		s = s[end:]
+	}
+}
+
+//isTokenByte reports whether s[i] continues a token (a word, an address, an encoded value)
+//a '.' only continues a token if a token byte follows it, so that a secret at the end of a sentence is redacted
+func isTokenByte(s string, i int) bool {
+	if i < 0 || i >= len(s) {
+		return false
+	}
+	c := s[i]
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c >= 0x80:
+		return true
+	case c == '-', c == '_', c == '@', c == '+', c == '%':
+		return true
+	case c == '.':
+		return i+1 < len(s) && s[i+1] != '.' && isTokenByte(s, i+1)
+	}
+	return false
+}
+
+//redactWriter is an io.Writer that redacts secrets before writing
+type redactWriter struct {
+	w io.Writer
+}
+
+//RedactWriter returns a writer that redacts the secrets in everything written to w
+//e.g. log.SetOutput(RedactWriter(os.Stderr))
+func RedactWriter(w io.Writer) io.Writer {
+	return redactWriter{w: w}
+}
+
+//Write writes p to the underlying writer with the secrets redacted
+func (rw redactWriter) Write(p []byte) (int, error) {
+	if _, err := rw.w.Write([]byte(Redact(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,99 @@
+package parsers
+
+import (
+	"bytes"
+	"testing"
+)
+
+//withSecrets registers secrets for a test and forgets them afterwards
+func withSecrets(t *testing.T, list ...string) {
+	secretsMu.Lock()
+	prev := secrets
+	secrets = nil
+	secretsMu.Unlock()
+	for _, s := range list {
+		AddSecret(s)
+	}
+	t.Cleanup(func() {
+		secretsMu.Lock()
+		secrets = prev
+		secretsMu.Unlock()
+	})
+}
+
+func TestRedact(t *testing.T) {
+	withSecrets(t, "s3cr3t-pass", "https://hooks.slack.com/services/T0/B0/XYZ", "Bearer abcdefgh", "short")
+	tests := []struct {
+		name, text, want string
+	}{
+		{"word", "login with s3cr3t-pass failed", "login with ****** failed"},
+		{"quoted", `auth "s3cr3t-pass"`, `auth "******"`},
+		{"end of sentence", "the password is s3cr3t-pass.", "the password is ******."},
+		{"key=value", "pwd=s3cr3t-pass&x=1", "pwd=******&x=1"},
+		{"url", `Post "https://hooks.slack.com/services/T0/B0/XYZ": EOF`, `Post "******": EOF`},
+		{"header", "Authorization: Bearer abcdefgh", "Authorization: ******"},
+		//an address or a longer token which merely contains a secret is left alone
+		{"address", "From: s3cr3t-pass@gmail.com", "From: s3cr3t-pass@gmail.com"},
+		{"longer token", "xs3cr3t-pass s3cr3t-pass2 s3cr3t-pass_x", "xs3cr3t-pass s3cr3t-pass2 s3cr3t-pass_x"},
+		{"domain", "s3cr3t-pass.example.com", "s3cr3t-pass.example.com"},
+		{"longer url", "https://hooks.slack.com/services/T0/B0/XYZW", "https://hooks.slack.com/services/T0/B0/XYZW"},
+		{"both", "s3cr3t-pass@x s3cr3t-pass", "s3cr3t-pass@x ******"},
+		//secrets shorter than minSecretLen are not registered
+		{"short", "a short text", "a short text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Redact(tt.text); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSecretsRegistersReferences(t *testing.T) {
+	withSecrets(t)
+	t.Setenv("TEST_SMTP_PASS", "from-the-env")
+	tests := []struct {
+		name     string
+		val      interface{}
+		want     interface{}
+		redacted bool
+	}{
+		//the placeholder of the shipped config file is not a secret to redact
+		{"clear", "--------", "--------", false},
+		{"env", "env:TEST_SMTP_PASS", "from-the-env", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSecrets(tt.val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSecrets(%v) = %v, want %v", tt.val, got, tt.want)
+			}
+			text := "value: " + tt.want.(string)
+			if redacted := Redact(text) != text; redacted != tt.redacted {
+				t.Errorf("%q redacted: %v, want %v", text, redacted, tt.redacted)
+			}
+		})
+	}
+	if _, err := resolveSecrets("env:TEST_UNSET_SECRET_VARIABLE"); err == nil {
+		t.Error("resolveSecrets of an unset variable returned no error")
+	}
+}
+
+func TestRedactWriter(t *testing.T) {
+	withSecrets(t, "xoxb-123456789")
+	var buf bytes.Buffer
+	n, err := RedactWriter(&buf).Write([]byte("token xoxb-123456789 rejected\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len("token xoxb-123456789 rejected\n") {
+		t.Errorf("Write returned %d, want the length of the input", n)
+	}
+	if buf.String() != "token ****** rejected\n" {
+		t.Errorf("RedactWriter wrote %q", buf.String())
+	}
+}
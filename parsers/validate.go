@@ -0,0 +1,521 @@
+package parsers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"net/url"
+	"notifier/consts"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//Diagnostic is a problem found in a config file by ValidateNotifiers or ValidateDefaults
+type Diagnostic struct {
+	File    string
+	Line    int //0 if the problem is not at a specific line (e.g. the file cannot be read)
+	Column  int
+	Key     string //path of the key, e.g. notifiers.smtpemailnotifier.SMTPPort
+	Warning bool   //a warning does not make the config invalid
+	Msg     string
+}
+
+//String formats a diagnostic as "file:line:column: error: key: message"
+func (d Diagnostic) String() string {
+	level := "error"
+	if d.Warning {
+		level = "warning"
+	}
+	pos := d.File
+	if d.Line > 0 {
+		pos += ":" + strconv.Itoa(d.Line) + ":" + strconv.Itoa(d.Column)
+	}
+	if d.Key == "" {
+		return pos + ": " + level + ": " + d.Msg
+	}
+	return pos + ": " + level + ": " + d.Key + ": " + d.Msg
+}
+
+//HasErrors reports whether any of the diagnostics is an error (not a warning)
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if !d.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+//validator collects the diagnostics of a config file
+type validator struct {
+	file  string
+	diags []Diagnostic
+}
+
+//errorf records an error at node n (which can be nil) for key
+func (v *validator) errorf(n *yaml.Node, key, format string, args ...interface{}) {
+	v.add(n, key, false, fmt.Sprintf(format, args...))
+}
+
+//warnf records a warning at node n (which can be nil) for key
+func (v *validator) warnf(n *yaml.Node, key, format string, args ...interface{}) {
+	v.add(n, key, true, fmt.Sprintf(format, args...))
+}
+
+//add records a diagnostic at the position of node n
+func (v *validator) add(n *yaml.Node, key string, warning bool, msg string) {
+	d := Diagnostic{File: v.file, Key: key, Warning: warning, Msg: msg}
+	if n != nil {
+		d.Line, d.Column = n.Line, n.Column
+	}
+	v.diags = append(v.diags, d)
+}
+
+//sorted returns the diagnostics in the order of their positions in the file
+func (v *validator) sorted() []Diagnostic {
+	sort.SliceStable(v.diags, func(i, j int) bool {
+		if v.diags[i].Line != v.diags[j].Line {
+			return v.diags[i].Line < v.diags[j].Line
+		}
+		return v.diags[i].Column < v.diags[j].Column
+	})
+	return v.diags
+}
+
+//field is a key and its value in a yaml mapping
+type field struct {
+	key *yaml.Node
+	val *yaml.Node
+}
+
+//fields returns the fields of a yaml mapping keyed by their lower case names
+//(the keys of the config files are case-insensitive)
+func fields(n *yaml.Node) map[string]field {
+	fs := make(map[string]field)
+	if n == nil || n.Kind != yaml.MappingNode {
+		return fs
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		fs[strings.ToLower(n.Content[i].Value)] = field{key: n.Content[i], val: n.Content[i+1]}
+	}
+	return fs
+}
+
+//yamlErrLine extracts the line number from an error of the yaml package, e.g. "yaml: line 12: ..."
+var yamlErrLine = regexp.MustCompile(`line (\d+):?\s*(.*)`)
+
+//parseFile reads a config file into a yaml node tree
+//returns the top-level mapping, or nil if the file is broken
+func (v *validator) parseFile(path string) *yaml.Node {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		v.errorf(nil, "", "%v", err)
+		return nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		d := Diagnostic{File: path, Msg: err.Error()}
+		if m := yamlErrLine.FindStringSubmatch(err.Error()); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+			d.Column = 1
+			d.Msg = m[2]
+		}
+		v.diags = append(v.diags, d)
+		return nil
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		v.errorf(&doc, "", "the file must be a yaml mapping")
+		return nil
+	}
+	return doc.Content[0]
+}
+
+/*------checks of a single value, each records a diagnostic if the value is invalid------*/
+
+//require checks that a field is set and is a non-empty scalar
+func (v *validator) require(fs map[string]field, name, key string, parent *yaml.Node) bool {
+	f, ok := fs[strings.ToLower(name)]
+	if !ok {
+		v.errorf(parent, key+"."+name, "required")
+		return false
+	}
+	if f.val.Kind != yaml.ScalarNode || f.val.Tag == "!!null" || f.val.Value == "" {
+		v.errorf(f.val, key+"."+name, "must be a non-empty value")
+		return false
+	}
+	return true
+}
+
+//checkBool checks a boolean, which may be on/off or yes/no as well as true/false
+func (v *validator) checkBool(n *yaml.Node, key string) {
+	if _, ok := parseBool(n.Value); ok && n.Kind == yaml.ScalarNode {
+		return
+	}
+	v.errorf(n, key, "must be on/off (or true/false), got %q", n.Value)
+}
+
+//checkOneOf checks that a value is one of choices (case-insensitive)
+func (v *validator) checkOneOf(n *yaml.Node, key string, choices ...string) {
+	for _, c := range choices {
+		if strings.EqualFold(n.Value, c) {
+			return
+		}
+	}
+	v.errorf(n, key, "must be one of %s, got %q", strings.Join(choices, ", "), n.Value)
+}
+
+//checkInt checks an integer in the range [min, max]
+func (v *validator) checkInt(n *yaml.Node, key string, min, max int) {
+	i, err := strconv.Atoi(n.Value)
+	if err != nil || n.Kind != yaml.ScalarNode {
+		v.errorf(n, key, "must be an integer, got %q", n.Value)
+		return
+	}
+	if i < min || i > max {
+		v.errorf(n, key, "%d out of range (%d-%d)", i, min, max)
+	}
+}
+
+//checkDuration checks a duration such as 2s or 1m
+func (v *validator) checkDuration(n *yaml.Node, key string) {
+	if _, err := time.ParseDuration(n.Value); err != nil {
+		v.errorf(n, key, "must be a duration such as 2s or 1m, got %q", n.Value)
+	}
+}
+
+//checkEmail checks an email address such as a@b.com or "Name <a@b.com>"
+func (v *validator) checkEmail(n *yaml.Node, key string) {
+	if _, err := mail.ParseAddress(n.Value); err != nil {
+		v.errorf(n, key, "invalid email address %q", n.Value)
+	}
+}
+
+//checkEmails checks a list of email addresses
+func (v *validator) checkEmails(n *yaml.Node, key string) {
+	if n.Kind != yaml.SequenceNode {
+		v.errorf(n, key, "must be a list of email addresses")
+		return
+	}
+	for i, item := range n.Content {
+		v.checkEmail(item, key+"["+strconv.Itoa(i)+"]")
+	}
+}
+
+//checkURL checks an absolute http(s) url
+func (v *validator) checkURL(n *yaml.Node, key string) {
+	u, err := url.Parse(n.Value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.errorf(n, key, "invalid http(s) url %q", n.Value)
+	}
+}
+
+//checkSecretURL checks a url which is a secret: a reference to a secret (see checkSecret), or else an http(s) url
+func (v *validator) checkSecretURL(n *yaml.Node, key string) {
+	if IsSecretRef(n.Value) {
+		v.checkSecret(n, key)
+		return
+	}
+	u, err := url.Parse(n.Value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.errorf(n, key, "invalid http(s) url")
+	}
+}
+
+//checkFile checks that a file referenced by a setting exists
+//a missing file is only a warning if optional is set
+func (v *validator) checkFile(n *yaml.Node, key string, optional bool) {
+	info, err := os.Stat(n.Value)
+	switch {
+	case err != nil && optional:
+		v.warnf(n, key, "file %q not found", n.Value)
+	case err != nil:
+		v.errorf(n, key, "file %q not found", n.Value)
+	case info.IsDir():
+		v.errorf(n, key, "%q is a directory", n.Value)
+	}
+}
+
+//checkSecret checks a secret (see ResolveSecret) without resolving it
+//a command is never run and an unset environment variable is only a warning
+func (v *validator) checkSecret(n *yaml.Node, key string) {
+	switch {
+	case strings.HasPrefix(n.Value, "file:"):
+		if _, err := os.Stat(strings.TrimPrefix(n.Value, "file:")); err != nil {
+			v.errorf(n, key, "secret file %q not found", strings.TrimPrefix(n.Value, "file:"))
+		}
+	case strings.HasPrefix(n.Value, "env:"):
+		if _, ok := os.LookupEnv(strings.TrimPrefix(n.Value, "env:")); !ok {
+			v.warnf(n, key, "environment variable %s is not set", strings.TrimPrefix(n.Value, "env:"))
+		}
+	}
+}
+
+/*------checks of the config files------*/
+
+//ValidateNotifiers checks the notifiers config file (see FindConfig)
+//types are the notifier types available (e.g. registry.Types())
+//the values are checked as they are in the file, without the environment variable overrides
+func ValidateNotifiers(file string, types []string) []Diagnostic {
+	path, err := FindConfig(file)
+	if err != nil {
+		return []Diagnostic{{File: file, Msg: err.Error()}}
+	}
+	v := &validator{file: path}
+	root := v.parseFile(path)
+	if root == nil {
+		return v.diags
+	}
+	ntfs, ok := fields(root)["notifiers"]
+	if !ok {
+		v.errorf(root, "notifiers", "required")
+		return v.diags
+	}
+	if ntfs.val.Kind != yaml.MappingNode {
+		v.errorf(ntfs.val, "notifiers", "must be a mapping of notifier names to their settings")
+		return v.diags
+	}
+	envNames := make(map[string]string)
+	for i := 0; i+1 < len(ntfs.val.Content); i += 2 {
+		name := ntfs.val.Content[i]
+		v.checkNotifier(name, ntfs.val.Content[i+1], types)
+		env := EnvName("notifiers." + name.Value)
+		if other, ok := envNames[env]; ok && !strings.EqualFold(other, name.Value) {
+			v.warnf(name, "notifiers."+name.Value, "same environment variables (%s_...) as notifier %s, neither can be overridden by them", env, other)
+		}
+		envNames[env] = name.Value
+	}
+	return v.sorted()
+}
+
+//checkNotifier checks the settings of a notifier
+func (v *validator) checkNotifier(name, settings *yaml.Node, types []string) {
+	key := "notifiers." + name.Value
+	if settings.Kind != yaml.MappingNode {
+		v.errorf(settings, key, "must be a mapping of settings")
+		return
+	}
+	fs := fields(settings)
+	if f, ok := fs["state"]; ok {
+		v.checkBool(f.val, key+".state")
+	} else {
+		v.errorf(name, key+".state", "required")
+	}
+	if f, ok := fs["retry"]; ok {
+		v.checkRetry(f.val, key+".retry")
+	}
+	if f, ok := fs["minlevel"]; ok {
+		v.checkOneOf(f.val, key+".minLevel", consts.LevelNames()...)
+	}
+	if !v.require(fs, "type", key, name) {
+		return
+	}
+	typ := fs["type"].val
+	known := false
+	for _, t := range types {
+		known = known || strings.EqualFold(t, typ.Value)
+	}
+	if !known {
+		v.errorf(typ, key+".type", "unknown type %q (%s)", typ.Value, strings.Join(types, ", "))
+		return
+	}
+
+	switch strings.ToLower(typ.Value) {
+	case consts.SMTPEmailType:
+		v.checkSmtpEmail(fs, key, name)
+	case consts.SlackType:
+		if v.require(fs, "token", key, name) {
+			v.checkSecret(fs["token"].val, key+".token")
+		}
+		v.checkSlackMessage(fs, key)
+		//the webhook urls of a slack notifier are optional (see setnotif add-webhook)
+		if _, ok := fs["webhookurls"]; ok {
+			v.checkWebhookURLs(fs, key, name, false)
+		}
+	case strings.ToLower(consts.SlackWebhookType):
+		v.checkSlackMessage(fs, key)
+		v.checkWebhookURLs(fs, key, name, true)
+	case consts.WebhookType:
+		if v.require(fs, "url", key, name) {
+			v.checkSecretURL(fs["url"].val, key+".url")
+		}
+		if f, ok := fs["headers"]; ok {
+			if f.val.Kind != yaml.MappingNode {
+				v.errorf(f.val, key+".headers", "must be a mapping of HTTP headers")
+			} else {
+				for i := 0; i+1 < len(f.val.Content); i += 2 {
+					v.checkSecret(f.val.Content[i+1], key+".headers."+f.val.Content[i].Value)
+				}
+			}
+		}
+		if f, ok := fs["method"]; ok {
+			v.checkOneOf(f.val, key+".method", "GET", "POST", "PUT", "PATCH", "DELETE")
+		}
+		if f, ok := fs["successcodes"]; ok {
+			if f.val.Kind != yaml.SequenceNode {
+				v.errorf(f.val, key+".successCodes", "must be a list of HTTP status codes")
+			} else {
+				for i, code := range f.val.Content {
+					v.checkInt(code, key+".successCodes["+strconv.Itoa(i)+"]", 100, 599)
+				}
+			}
+		}
+	}
+}
+
+//slackColorRe matches the hex colors of slack attachments
+var slackColorRe = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+//checkWebhookURLs checks the WebhookURLs of a slack or slackWebhook notifier: a list of urls (see checkSecretURL)
+//which must have at least one url if required
+func (v *validator) checkWebhookURLs(fs map[string]field, key string, name *yaml.Node, required bool) {
+	f, ok := fs["webhookurls"]
+	switch {
+	case !ok:
+		v.errorf(name, key+".WebhookURLs", "required")
+	case required && (f.val.Kind != yaml.SequenceNode || len(f.val.Content) == 0):
+		v.errorf(f.val, key+".WebhookURLs", "must be a list of one or more urls")
+	case f.val.Kind != yaml.SequenceNode && f.val.Tag != "!!null":
+		v.errorf(f.val, key+".WebhookURLs", "must be a list of urls")
+	default:
+		for i, u := range f.val.Content {
+			v.checkSecretURL(u, key+".WebhookURLs["+strconv.Itoa(i)+"]")
+		}
+	}
+}
+
+//checkSlackMessage checks the message settings common to the types "slack" and "slackWebhook"
+func (v *validator) checkSlackMessage(fs map[string]field, key string) {
+	if f, ok := fs["color"]; ok && f.val.Value != "" {
+		switch f.val.Value {
+		case "good", "warning", "danger":
+		default:
+			if !slackColorRe.MatchString(f.val.Value) {
+				v.errorf(f.val, key+".color", "must be good, warning, danger or a hex color such as #439FE0, got %q", f.val.Value)
+			}
+		}
+	}
+	if f, ok := fs["iconurl"]; ok && f.val.Value != "" {
+		v.checkURL(f.val, key+".iconURL")
+	}
+	for _, name := range []string{"unfurlLinks", "unfurlMedia"} {
+		if f, ok := fs[strings.ToLower(name)]; ok {
+			v.checkBool(f.val, key+"."+name)
+		}
+	}
+}
+
+//checkRetry checks the retry policy of a notifier
+func (v *validator) checkRetry(n *yaml.Node, key string) {
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, key, "must be a mapping of retry settings")
+		return
+	}
+	fs := fields(n)
+	if f, ok := fs["maxattempts"]; ok {
+		v.checkInt(f.val, key+".maxAttempts", 1, 100)
+	}
+	if f, ok := fs["basedelay"]; ok {
+		v.checkDuration(f.val, key+".baseDelay")
+	}
+	if f, ok := fs["maxdelay"]; ok {
+		v.checkDuration(f.val, key+".maxDelay")
+	}
+	if f, ok := fs["jitter"]; ok {
+		if j, err := strconv.ParseFloat(f.val.Value, 64); err != nil || j < 0 || j > 1 {
+			v.errorf(f.val, key+".jitter", "must be a number between 0 and 1, got %q", f.val.Value)
+		}
+	}
+}
+
+//checkSmtpEmail checks the settings of a notifier of type "smtpemail"
+func (v *validator) checkSmtpEmail(fs map[string]field, key string, name *yaml.Node) {
+	if v.require(fs, "account", key, name) {
+		v.checkEmail(fs["account"].val, key+".account")
+	}
+	v.require(fs, "SMTPHost", key, name)
+	if v.require(fs, "SMTPPort", key, name) {
+		v.checkInt(fs["smtpport"].val, key+".SMTPPort", 1, 65535)
+	}
+	if f, ok := fs["pwd"]; ok {
+		v.checkSecret(f.val, key+".pwd")
+	}
+	if f, ok := fs["tlsmode"]; ok {
+		v.checkOneOf(f.val, key+".tlsMode", consts.TLSImplicit, consts.TLSStartTLS, consts.TLSNone)
+	}
+	if f, ok := fs["auth"]; ok {
+		v.checkOneOf(f.val, key+".auth", consts.AuthPlain, consts.AuthLogin, consts.AuthCRAMMD5, consts.AuthXOAUTH2, consts.AuthNone)
+		_, hasFile := fs["tokenfile"]
+		_, hasCmd := fs["tokencmd"]
+		if strings.EqualFold(f.val.Value, consts.AuthXOAUTH2) && !hasFile && !hasCmd {
+			v.errorf(f.val, key+".auth", "tokenFile or tokenCmd is needed for xoauth2")
+		}
+	}
+	for _, name := range []string{"tokenFile", "caFile", "certFile", "keyFile"} {
+		if f, ok := fs[strings.ToLower(name)]; ok && f.val.Value != "" {
+			v.checkFile(f.val, key+"."+name, false)
+		}
+	}
+	_, hasCert := fs["certfile"]
+	_, hasKey := fs["keyfile"]
+	if hasCert != hasKey {
+		v.errorf(name, key, "both certFile and keyFile are needed for a client certificate")
+	}
+	if f, ok := fs["mintlsversion"]; ok {
+		v.checkTLSVersion(f.val, key+".minTLSVersion")
+	}
+	if f, ok := fs["insecureskipverify"]; ok {
+		v.checkBool(f.val, key+".insecureSkipVerify")
+	}
+}
+
+//checkTLSVersion checks a minTLSVersion with ParseTLSVersion, as it is parsed when sending
+//an unquoted version is a number in yaml (1.0 is read as 1), so it must be quoted
+func (v *validator) checkTLSVersion(n *yaml.Node, key string) {
+	if _, ok := ParseTLSVersion(n.Value); !ok {
+		v.errorf(n, key, "must be one of 1.0, 1.1, 1.2, 1.3 (optionally prefixed with TLS), got %q", n.Value)
+	} else if n.Tag == "!!float" || n.Tag == "!!int" {
+		v.errorf(n, key, "must be quoted, e.g. \"%s\" (an unquoted version is read as a number)", n.Value)
+	}
+}
+
+//ValidateDefaults checks the default settings file (see FindConfig)
+//the values are checked as they are in the file, without the environment variable overrides
+func ValidateDefaults(file string) []Diagnostic {
+	path, err := FindConfig(file)
+	if err != nil {
+		return []Diagnostic{{File: file, Msg: err.Error()}}
+	}
+	v := &validator{file: path}
+	root := v.parseFile(path)
+	if root == nil {
+		return v.diags
+	}
+	dflts, ok := fields(root)["defaults"]
+	if !ok {
+		v.errorf(root, "defaults", "required")
+		return v.diags
+	}
+	if dflts.val.Kind != yaml.MappingNode {
+		v.errorf(dflts.val, "defaults", "must be a mapping of default settings")
+		return v.diags
+	}
+	fs := fields(dflts.val)
+	//missing files are ignored when sending (e.g. the message is used if the message file is not available)
+	for _, name := range []string{"emailListFile", "slackListFile", "messageFile"} {
+		if f, ok := fs[strings.ToLower(name)]; ok && f.val.Value != "" {
+			v.checkFile(f.val, "defaults."+name, true)
+		}
+	}
+	for _, name := range []string{"cc", "bcc", "replyTo"} {
+		if f, ok := fs[strings.ToLower(name)]; ok {
+			v.checkEmails(f.val, "defaults."+name)
+		}
+	}
+	return v.sorted()
+}
@@ -0,0 +1,487 @@
+package parsers
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"notifier/consts"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+//the config files are edited as yaml node trees rather than through viper
+//and only the changed values are written back into the text of the files, see saveYAML
+
+//loadYAML reads a config file into a yaml node tree
+func loadYAML(path string) (*yaml.Node, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New(path + " is not a yaml mapping")
+	}
+	return &doc, nil
+}
+
+//saveYAML writes a yaml node tree back to a config file
+//only the values changed since the file was read (see setYAML) are rewritten in the text of the file (see spliceYAML)
+//so that the comments, the quotes and the layout of the rest of the file are kept as they are
+//the whole tree is encoded only if the changes cannot be spliced, see encodeYAML
+//the new version is written to a temporary file and renamed, so that a crash never leaves a broken config file
+//and the previous version is kept as path + consts.BackupExt
+func saveYAML(path string, doc *yaml.Node) error {
+	prev, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	var prevDoc yaml.Node
+	if err := yaml.Unmarshal(prev, &prevDoc); err != nil {
+		return err
+	}
+	data, ok := spliceYAML(prev, &prevDoc, doc)
+	if !ok {
+		if data, err = encodeYAML(prev, doc); err != nil {
+			return err
+		}
+	}
+
+	if err := ioutil.WriteFile(path+consts.BackupExt, prev, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return WriteAtomic(path, data, info.Mode().Perm())
+}
+
+//encodeYAML encodes a whole yaml node tree, with the comments
+//the "---" and "..." document markers of the previous version of the file are kept as well (the yaml package drops them)
+func encodeYAML(prev []byte, doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if docStartRe.Match(prev) {
+		buf.WriteString("---\n")
+	}
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	if docEndRe.Match(prev) {
+		buf.WriteString("...\n")
+	}
+	return buf.Bytes(), nil
+}
+
+//docStartRe and docEndRe match the document markers at the beginning and the end of a file
+var (
+	docStartRe = regexp.MustCompile(`^(?:\s*#[^\n]*\n|\s*\n)*---[ \t]*(?:\r?\n|$)`)
+	docEndRe   = regexp.MustCompile(`\n\.\.\.[ \t]*(?:\r?\n\s*)*$`)
+)
+
+//yamlSplice is the text of a file parsed into a yaml node tree, and the changes to it
+//the changes replace the bytes [start, end) of the text, an insertion has start == end
+type yamlSplice struct {
+	data    []byte
+	lines   []int  //offset of each line
+	newline string //"\n", or "\r\n" if the file has windows line endings
+	edits   []yamlTextEdit
+}
+
+type yamlTextEdit struct {
+	start, end int
+	text       string
+}
+
+//spliceYAML rewrites in data (parsed into prevDoc) the nodes of doc which differ from prevDoc
+//a changed scalar on a single line is replaced in place, a changed collection is replaced with its key
+//and the keys added to a block mapping (see setYAML) are inserted after its last line
+//returns false if the changes cannot be spliced, e.g. a key removed, or a change in a flow collection or a multi-line scalar
+func spliceYAML(data []byte, prevDoc, doc *yaml.Node) ([]byte, bool) {
+	if len(prevDoc.Content) == 0 || len(doc.Content) == 0 {
+		return nil, false
+	}
+	s := &yamlSplice{data: data, lines: []int{0}, newline: "\n"}
+	for i, c := range data {
+		if c == '\n' {
+			s.lines = append(s.lines, i+1)
+		}
+	}
+	if bytes.Contains(data, []byte("\r\n")) {
+		s.newline = "\r\n"
+	}
+	if !s.diff(prevDoc.Content[0], doc.Content[0]) {
+		return nil, false
+	}
+	sort.Slice(s.edits, func(i, j int) bool { return s.edits[i].start > s.edits[j].start })
+	out := append([]byte(nil), data...)
+	for _, e := range s.edits {
+		out = append(out[:e.start], append([]byte(e.text), out[e.end:]...)...)
+	}
+	return out, true
+}
+
+//diff records the edits turning the node prev into node, returns false if they cannot be spliced
+func (s *yamlSplice) diff(prev, node *yaml.Node) bool {
+	if sameYAML(prev, node) {
+		return true
+	}
+	switch {
+	case prev.Kind == yaml.ScalarNode && node.Kind == yaml.ScalarNode:
+		return s.replaceScalar(prev, node)
+	case prev.Kind == yaml.MappingNode && node.Kind == yaml.MappingNode:
+		if prev.Style&yaml.FlowStyle != 0 || len(prev.Content) == 0 || len(node.Content) < len(prev.Content) {
+			return false
+		}
+		for i := 0; i+1 < len(prev.Content); i += 2 {
+			if prev.Content[i].Value != node.Content[i].Value {
+				return false
+			}
+			if !s.diff(prev.Content[i+1], node.Content[i+1]) && !s.replacePair(prev.Content[i], prev.Content[i+1], node.Content[i+1]) {
+				return false
+			}
+		}
+		if len(node.Content) == len(prev.Content) {
+			return true
+		}
+		//the new keys are appended to the mapping, with the indentation of its keys
+		last, ok := s.lastLine(prev)
+		if !ok {
+			return false
+		}
+		return s.insertLines(last, prev.Content[0].Column-1, node.Content[len(prev.Content):]...)
+	}
+	return false
+}
+
+//replaceScalar replaces a scalar on a single line with the scalar node, returns false if either spans several lines
+func (s *yamlSplice) replaceScalar(prev, node *yaml.Node) bool {
+	start, end, ok := s.scalarSpan(prev)
+	if !ok {
+		return false
+	}
+	scalar := *node
+	scalar.HeadComment, scalar.LineComment, scalar.FootComment = "", "", ""
+	text, err := yaml.Marshal(&scalar)
+	if err != nil {
+		return false
+	}
+	val := strings.TrimSuffix(string(text), "\n")
+	if strings.Contains(val, "\n") {
+		return false
+	}
+	s.edits = append(s.edits, yamlTextEdit{start: start, end: end, text: val})
+	return true
+}
+
+//replacePair replaces the lines of a key and its value prev with the key and the value node
+//the comments before and after these lines are kept, the line comment is written again
+func (s *yamlSplice) replacePair(key, prev, node *yaml.Node) bool {
+	first := s.lineOffset(key.Line)
+	if first < 0 || strings.TrimSpace(string(s.data[first:s.offset(key.Line, key.Column)])) != "" {
+		return false
+	}
+	last, ok := s.lastLine(prev)
+	if !ok {
+		return false
+	}
+	end := s.lineOffset(last + 1)
+	if end < 0 {
+		end = len(s.data)
+	}
+	text, ok := s.pairLines(key.Column-1, key, node)
+	if !ok {
+		return false
+	}
+	s.edits = append(s.edits, yamlTextEdit{start: first, end: end, text: text})
+	return true
+}
+
+//insertLines inserts the key and value pairs after line, indented by indent spaces
+func (s *yamlSplice) insertLines(line, indent int, pairs ...*yaml.Node) bool {
+	text, ok := s.pairLines(indent, pairs...)
+	if !ok {
+		return false
+	}
+	at := s.lineOffset(line + 1)
+	if at < 0 {
+		at = len(s.data)
+		if !bytes.HasSuffix(s.data, []byte("\n")) {
+			//the last line of the file has no line ending
+			text = s.newline + text
+		}
+	}
+	s.edits = append(s.edits, yamlTextEdit{start: at, end: at, text: text})
+	return true
+}
+
+//pairLines encodes the key and value pairs into lines indented by indent spaces
+//without their head and foot comments, which are kept in the text around them
+func (s *yamlSplice) pairLines(indent int, pairs ...*yaml.Node) (string, bool) {
+	mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key, val := *pairs[i], *pairs[i+1]
+		key.HeadComment, key.FootComment = "", ""
+		val.HeadComment, val.FootComment = "", ""
+		mapping.Content = append(mapping.Content, &key, &val)
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(mapping); err != nil {
+		return "", false
+	}
+	if err := enc.Close(); err != nil {
+		return "", false
+	}
+	var text strings.Builder
+	for _, l := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		text.WriteString(strings.Repeat(" ", indent) + l + s.newline)
+	}
+	return text.String(), true
+}
+
+//lineOffset returns the offset of a line (from 1), or -1 after the last line ending
+func (s *yamlSplice) lineOffset(line int) int {
+	if line < 1 || line > len(s.lines) || s.lines[line-1] == len(s.data) && line > 1 {
+		return -1
+	}
+	return s.lines[line-1]
+}
+
+//offset returns the offset of a line and column (from 1, in characters as counted by the yaml package)
+func (s *yamlSplice) offset(line, column int) int {
+	off := s.lineOffset(line)
+	for col := 1; off >= 0 && col < column && off < len(s.data); col++ {
+		_, size := utf8.DecodeRune(s.data[off:])
+		off += size
+	}
+	return off
+}
+
+//scalarSpan returns the offsets of a scalar written on a single line, plain or quoted
+func (s *yamlSplice) scalarSpan(n *yaml.Node) (int, int, bool) {
+	start := s.offset(n.Line, n.Column)
+	if start < 0 || n.Style&(yaml.LiteralStyle|yaml.FoldedStyle|yaml.TaggedStyle) != 0 {
+		return 0, 0, false
+	}
+	rest := s.data[start:]
+	if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	switch {
+	case n.Style&yaml.DoubleQuotedStyle != 0:
+		for i := 1; i < len(rest); i++ {
+			switch rest[i] {
+			case '\\':
+				i++
+			case '"':
+				return start, start + i + 1, true
+			}
+		}
+	case n.Style&yaml.SingleQuotedStyle != 0:
+		for i := 1; i < len(rest); i++ {
+			if rest[i] != '\'' {
+				continue
+			}
+			if i+1 < len(rest) && rest[i+1] == '\'' {
+				i++
+				continue
+			}
+			return start, start + i + 1, true
+		}
+	default:
+		if bytes.HasPrefix(rest, []byte(n.Value)) && n.Value != "" {
+			return start, start + len(n.Value), true
+		}
+	}
+	return 0, 0, false
+}
+
+//lastLine returns the last line of a node written in block style, false if it cannot be told
+//(a multi-line plain or quoted scalar, whose value does not tell its lines)
+func (s *yamlSplice) lastLine(n *yaml.Node) (int, bool) {
+	last := n.Line
+	switch {
+	case n.Kind != yaml.ScalarNode:
+	case n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		last += strings.Count(strings.TrimSuffix(n.Value, "\n"), "\n") + 1
+	case n.Value == "" && n.Style == 0:
+		//an empty value, e.g. "key:" with nothing after it
+	default:
+		if _, _, ok := s.scalarSpan(n); !ok {
+			return 0, false
+		}
+	}
+	for _, c := range n.Content {
+		l, ok := s.lastLine(c)
+		if !ok {
+			return 0, false
+		}
+		if l > last {
+			last = l
+		}
+	}
+	return last, true
+}
+
+//sameYAML reports whether two nodes have the same values, regardless of their comments and positions
+func sameYAML(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.Tag != b.Tag || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !sameYAML(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+//WriteAtomic writes data to a temporary file in the directory of path and renames it to path
+//so that the readers of path never see a partly written file
+func WriteAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+//parseBool parses a boolean of the config files, which may be on/off or yes/no as well as true/false
+//ok is false if s is not a boolean
+func parseBool(s string) (val bool, ok bool) {
+	switch strings.ToLower(s) {
+	case "true", "on", "yes", "y":
+		return true, true
+	case "false", "off", "no", "n":
+		return false, true
+	}
+	return false, false
+}
+
+//boolWords are the spellings of the booleans, as pairs of true and false
+var boolWords = [][2]string{{"true", "false"}, {"on", "off"}, {"yes", "no"}, {"y", "n"}}
+
+//formatBool spells val in the same way as like, which must be a boolean accepted by parseBool
+//e.g. formatBool("off", true) is "on", and formatBool("False", true) is "True"
+func formatBool(like string, val bool) string {
+	for _, words := range boolWords {
+		if !strings.EqualFold(like, words[0]) && !strings.EqualFold(like, words[1]) {
+			continue
+		}
+		word := words[1]
+		if val {
+			word = words[0]
+		}
+		switch like {
+		case strings.ToUpper(like):
+			return strings.ToUpper(word)
+		case strings.ToLower(like):
+			return word
+		}
+		return strings.ToUpper(word[:1]) + word[1:]
+	}
+	if val {
+		return "true"
+	}
+	return "false"
+}
+
+//lookupYAML returns the value node of a key such as "notifiers.slacknotifier.token"
+//the keys are case-insensitive as in viper, nil is returned if the key is not found
+func lookupYAML(doc *yaml.Node, item string) *yaml.Node {
+	node := doc.Content[0]
+	for _, key := range strings.Split(item, ".") {
+		_, val := mappingField(node, key)
+		if val == nil {
+			return nil
+		}
+		node = val
+	}
+	return node
+}
+
+//mappingField returns the key and value nodes of a key in a mapping node (case-insensitive)
+func mappingField(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+//setYAML sets the value of a key such as "notifiers.slacknotifier.token" to newVal
+//the missing keys are added at the end of their mappings
+//the comments of the replaced value are kept
+func setYAML(doc *yaml.Node, item string, newVal interface{}) error {
+	node := doc.Content[0]
+	keys := strings.Split(item, ".")
+	for i, key := range keys {
+		if node.Kind != yaml.MappingNode {
+			return errors.New(strings.Join(keys[:i], ".") + " is not a mapping")
+		}
+		_, val := mappingField(node, key)
+		if val == nil {
+			val = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, val)
+		}
+		if i < len(keys)-1 {
+			node = val
+			continue
+		}
+
+		//a boolean is written in the same style as the replaced one, e.g. off is toggled to on rather than true
+		if b, isBool := newVal.(bool); isBool && val.Kind == yaml.ScalarNode {
+			if _, ok := parseBool(val.Value); ok {
+				val.Value = formatBool(val.Value, b)
+				return nil
+			}
+		}
+
+		var newNode yaml.Node
+		if err := newNode.Encode(newVal); err != nil {
+			return err
+		}
+		newNode.HeadComment, newNode.LineComment, newNode.FootComment = val.HeadComment, val.LineComment, val.FootComment
+		*val = newNode
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package parsers
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+//set returns an edit setting the keys of the config file to values, in order (see setYAML)
+func set(kv ...interface{}) func(doc *yaml.Node) error {
+	return func(doc *yaml.Node) error {
+		for i := 0; i+1 < len(kv); i += 2 {
+			if err := setYAML(doc, kv[i].(string), kv[i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestSpliceYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		edit func(doc *yaml.Node) error
+		want string
+		ok   bool
+	}{
+		{
+			name: "scalar with comments",
+			src:  "# notifiers\nnotifiers:\n  sl:\n    # the token\n    token: old # keep me\n    state: on\n",
+			edit: set("notifiers.sl.token", "xoxb-new"),
+			want: "# notifiers\nnotifiers:\n  sl:\n    # the token\n    token: xoxb-new # keep me\n    state: on\n",
+			ok:   true,
+		},
+		{
+			name: "boolean style",
+			src:  "notifiers:\n  sl:\n    state: Off\n",
+			edit: set("notifiers.sl.state", true),
+			want: "notifiers:\n  sl:\n    state: On\n",
+			ok:   true,
+		},
+		{
+			name: "quoted scalar",
+			src:  "a:\n  b: \"x y\"   # c\n  d: 'it''s'\n",
+			edit: set("a.b", "z", "a.d", "w"),
+			want: "a:\n  b: z   # c\n  d: w\n",
+			ok:   true,
+		},
+		{
+			name: "crlf",
+			src:  "# head\r\na:\r\n  b: x\r\n",
+			edit: set("a.b", "v", "a.c", "z"),
+			want: "# head\r\na:\r\n  b: v\r\n  c: z\r\n",
+			ok:   true,
+		},
+		{
+			name: "added keys",
+			src:  "a:\n  b: x\n# foot\nc: 1\n",
+			edit: set("a.new", "v", "d.e", "w"),
+			want: "a:\n  b: x\n  new: v\n# foot\nc: 1\nd:\n  e: w\n",
+			ok:   true,
+		},
+		{
+			name: "no trailing newline",
+			src:  "a:\n  b: x",
+			edit: set("a.c", "v"),
+			want: "a:\n  b: x\n  c: v\n",
+			ok:   true,
+		},
+		{
+			name: "literal replaced by a scalar",
+			src:  "a:\n  b: |\n    line1\n    line2\n  c: x # c\n",
+			edit: set("a.b", "v"),
+			want: "a:\n  b: v\n  c: x # c\n",
+			ok:   true,
+		},
+		{
+			name: "list replaced",
+			src:  "a:\n  hooks:\n    - u1 # first\n    - u2\n  c: x\n",
+			edit: set("a.hooks", []string{"u3"}),
+			want: "a:\n  hooks:\n    - u3\n  c: x\n",
+			ok:   true,
+		},
+		{
+			name: "scalar with a new line",
+			src:  "a:\n  b: x\n",
+			edit: set("a.b", "l1\nl2"),
+			want: "a:\n  b: |-\n    l1\n    l2\n",
+			ok:   true,
+		},
+		{
+			name: "unicode columns",
+			src:  "é: ü\nb: ü # c\n",
+			edit: set("b", "v"),
+			want: "é: ü\nb: v # c\n",
+			ok:   true,
+		},
+		{
+			name: "multi-line plain scalar",
+			src:  "a: foo\n  bar\nb: x\n",
+			edit: set("b", "z"),
+			want: "a: foo\n  bar\nb: z\n",
+			ok:   true,
+		},
+		{
+			name: "multi-line plain scalar replaced",
+			src:  "a:\n  b: foo\n    bar\nc: x\n",
+			edit: set("a.b", "z"),
+			ok:   false,
+		},
+		{
+			name: "after a multi-line plain scalar",
+			src:  "a:\n  b: foo\n    bar\nc: x\n",
+			edit: set("a.d", "z"),
+			ok:   false,
+		},
+		{
+			name: "flow mapping",
+			src:  "a: {b: x, c: w} # c\nd: 1\n",
+			edit: set("a.e", "z"),
+			want: "a: {b: x, c: w, e: z} # c\nd: 1\n",
+			ok:   true,
+		},
+		{
+			name: "quoted boolean word",
+			src:  "a:\n  b: x\n",
+			edit: set("a.b", "y"),
+			want: "a:\n  b: \"y\"\n",
+			ok:   true,
+		},
+		{
+			name: "empty value",
+			src:  "a:\n  b:\n  c: x\nd: 1\n",
+			edit: set("a.e", "v"),
+			want: "a:\n  b:\n  c: x\n  e: v\nd: 1\n",
+			ok:   true,
+		},
+		{
+			name: "removed key",
+			src:  "a: x\nb: y\n",
+			edit: func(doc *yaml.Node) error {
+				doc.Content[0].Content = doc.Content[0].Content[:2]
+				return nil
+			},
+			ok: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prevDoc, doc yaml.Node
+			if err := yaml.Unmarshal([]byte(tt.src), &prevDoc); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.src), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.edit(&doc); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := spliceYAML([]byte(tt.src), &prevDoc, &doc)
+			if ok != tt.ok {
+				t.Fatalf("spliceYAML ok = %v, want %v (got %q)", ok, tt.ok, got)
+			}
+			if !ok {
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("spliceYAML =\n%q\nwant\n%q", got, tt.want)
+			}
+			//the spliced text has the values of doc
+			var spliced yaml.Node
+			if err := yaml.Unmarshal(got, &spliced); err != nil {
+				t.Fatalf("spliced text is not yaml: %v", err)
+			}
+			if !sameYAML(spliced.Content[0], doc.Content[0]) {
+				t.Errorf("spliced text %q does not have the values set", got)
+			}
+		})
+	}
+}
+
+func TestFormatBool(t *testing.T) {
+	tests := []struct {
+		like string
+		val  bool
+		want string
+	}{
+		{"off", true, "on"},
+		{"ON", false, "OFF"},
+		{"False", true, "True"},
+		{"y", false, "n"},
+		{"yes", true, "yes"},
+		{"maybe", true, "true"},
+	}
+	for _, tt := range tests {
+		if got := formatBool(tt.like, tt.val); got != tt.want {
+			t.Errorf("formatBool(%q, %v) = %q, want %q", tt.like, tt.val, got, tt.want)
+		}
+	}
+}
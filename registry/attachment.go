@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"notifier/consts"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Attachment is a file sent along with a notification
+//by the notifiers that support it (email attachments, slack file uploads)
+type Attachment struct {
+	Name        string //file name without directory
+	ContentType string //MIME type, e.g. "text/plain; charset=utf-8"
+	Data        []byte
+}
+
+//LoadAttachments reads the files to be attached to a notification
+//and detects their content types, from the file extension or else from the content
+//returns ATTACH_ERR if a file cannot be read or the files exceed the size limits
+func LoadAttachments(paths []string) ([]Attachment, consts.ERR) {
+	var attachments []Attachment
+	total := int64(0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Println(err)
+			return nil, consts.ATTACH_ERR
+		}
+		if info.IsDir() {
+			log.Println("attachment", path, "is a directory")
+			return nil, consts.ATTACH_ERR
+		}
+		if info.Size() > consts.MAX_ATTACH_SIZE {
+			log.Printf("attachment %s is too large (%d bytes, maximum %d bytes)\n", path, info.Size(), consts.MAX_ATTACH_SIZE)
+			return nil, consts.ATTACH_ERR
+		}
+		total += info.Size()
+		if total > consts.MAX_ATTACH_TOTAL_SIZE {
+			log.Printf("attachments are too large (maximum %d bytes in total)\n", consts.MAX_ATTACH_TOTAL_SIZE)
+			return nil, consts.ATTACH_ERR
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Println(err)
+			return nil, consts.ATTACH_ERR
+		}
+		attachments = append(attachments, Attachment{
+			Name:        filepath.Base(path),
+			ContentType: detectContentType(path, data),
+			Data:        data,
+		})
+	}
+	return attachments, consts.NIL
+}
+
+//detectContentType returns the MIME type of a file from its extension
+//or sniffs it from the content if the extension is unknown (e.g. error.log)
+func detectContentType(path string, data []byte) string {
+	if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+		return ct
+	}
+	ct := http.DetectContentType(data)
+	//http.DetectContentType never fails, "application/octet-stream" is its fallback
+	if strings.HasPrefix(ct, "text/plain") {
+		return "text/plain; charset=utf-8"
+	}
+	return ct
+}
@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"notifier/consts"
+	"strconv"
+	"time"
+)
+
+//Delivery is the outcome of delivering a notification to one recipient
+type Delivery struct {
+	Notifier   string //name of the notifier in the config file
+	Type       string //type of the notifier
+	Recipient  string //email address, slack ID, webhook (see URLRecipient)...
+	Key        string //stable key of a Recipient which is only a label, e.g. the hash of a webhook url (see URLKey)
+	Err        consts.ERR
+	Detail     string //error message returned by the server or the library, if any
+	Time       time.Time
+	Attempts   int           //number of attempts made for the recipient
+	RetryAfter time.Duration //wait time requested by the server (e.g. HTTP 429 Retry-After), if any
+	DryRun     bool          //nothing was sent, see WithDryRun
+}
+
+//NewDelivery records the outcome of delivering to a recipient at the current time
+//detail can be nil if there is no error message
+func NewDelivery(recipient string, err consts.ERR, detail error) Delivery {
+	dlv := Delivery{
+		Recipient: recipient,
+		Err:       err,
+		Time:      time.Now(),
+		Attempts:  1,
+	}
+	if detail != nil {
+		dlv.Detail = detail.Error()
+	}
+	return dlv
+}
+
+//URLRecipient names a webhook in the deliveries without its url, which is a secret (see parsers.SecretKeys)
+//e.g. "webhook#2 (hooks.slack.com)", n is the position of the url in the settings from 1, or 0 if there is only one url
+func URLRecipient(n int, rawURL string) string {
+	name := "webhook"
+	if n > 0 {
+		name += "#" + strconv.Itoa(n)
+	}
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		name += " (" + u.Host + ")"
+	}
+	return name
+}
+
+//URLKey is the key of the deliveries to a webhook url (see Delivery.Key), a hash which does not reveal the url
+//unlike the label of URLRecipient, it does not change when the urls of the settings are reordered
+func URLKey(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return hex.EncodeToString(sum[:8])
+}
+
+//NewDeliveries records the same outcome for all recipients
+//e.g. a connection error that occurs before anything can be delivered
+func NewDeliveries(recipients []string, err consts.ERR, detail error) []Delivery {
+	deliveries := make([]Delivery, 0, len(recipients))
+	for _, rcpt := range recipients {
+		deliveries = append(deliveries, NewDelivery(rcpt, err, detail))
+	}
+	return deliveries
+}
+
+//Status returns "success", "failed" or "dry-run"
+func (dlv Delivery) Status() string {
+	if dlv.DryRun {
+		return "dry-run"
+	}
+	if dlv.Err == consts.NIL {
+		return "success"
+	}
+	return "failed"
+}
+
+//FirstErr returns the ERR of the first failed delivery, or NIL if all deliveries succeeded
+func FirstErr(deliveries []Delivery) consts.ERR {
+	for _, dlv := range deliveries {
+		if dlv.Err != consts.NIL {
+			return dlv.Err
+		}
+	}
+	return consts.NIL
+}
+
+//NewResult builds the Result of a notifier from its deliveries
+//err is the first error occurred, or the error before anything was delivered
+func NewResult(name, typ string, deliveries []Delivery, err consts.ERR) Result {
+	for i := range deliveries {
+		deliveries[i].Notifier = name
+		deliveries[i].Type = typ
+	}
+	return Result{Notifier: name, Type: typ, Err: err, Deliveries: deliveries}
+}
+
+//Succeeded returns the number of successful deliveries
+func (res Result) Succeeded() int {
+	n := 0
+	for _, dlv := range res.Deliveries {
+		if dlv.Err == consts.NIL {
+			n++
+		}
+	}
+	return n
+}
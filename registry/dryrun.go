@@ -0,0 +1,31 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"notifier/consts"
+)
+
+//dryRunKey is the context key of the dry-run writer
+type dryRunKey struct{}
+
+//WithDryRun returns a context in which the notifiers build everything as usual
+//but print what would be sent to w instead of opening any connection
+func WithDryRun(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, w)
+}
+
+//DryRun returns the writer set by WithDryRun, or nil if ctx is not in dry-run mode
+func DryRun(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(dryRunKey{}).(io.Writer)
+	return w
+}
+
+//DryRunDeliveries records the deliveries to recipients that were printed in dry-run mode
+func DryRunDeliveries(recipients []string) []Delivery {
+	deliveries := NewDeliveries(recipients, consts.SUCCESS, nil)
+	for i := range deliveries {
+		deliveries[i].DryRun = true
+	}
+	return deliveries
+}
@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"notifier/consts"
+	"notifier/parsers"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Notification is the content to be sent by every notifier in one invocation
+type Notification struct {
+	Subject string
+	Message string
+	//Level is the severity of the notification, which formats it (e.g. slack color, email subject prefix)
+	Level consts.Level `json:",omitempty"`
+	//HTML is the message in HTML for the notifiers that support it (e.g. email), Message is its plain-text version
+	HTML string
+	//target IDs keyed by recipient kind (e.g. consts.EmailRecipients)
+	Recipients map[string][]string
+	//Block Kit blocks of the message for the notifiers that support it (e.g. slack), see slackNotify.ComposeBlocks
+	Blocks []map[string]interface{} `json:",omitempty"`
+	//addresses the replies should be sent to, for the notifiers that support it (e.g. email)
+	ReplyTo []string `json:",omitempty"`
+	//files sent along with the message, see LoadAttachments
+	Attachments []Attachment `json:",omitempty"`
+}
+
+//To returns the target IDs of a specific recipient kind
+func (n Notification) To(kind string) []string {
+	return n.Recipients[kind]
+}
+
+//Result is the outcome of sending a notification with one notifier
+type Result struct {
+	Notifier string //name of the notifier in the config file
+	Type     string //type of the notifier
+	//Err is the first error occurred, or an error before anything was delivered (e.g. no target)
+	Err        consts.ERR
+	Deliveries []Delivery //outcome for each recipient
+}
+
+//Notifier is the interface that every notifier (email, slack, ...) implements
+type Notifier interface {
+	//Name returns the name of the notifier in the config file
+	Name() string
+	//Validate checks the notifier settings before sending anything
+	Validate() consts.ERR
+	//Send sends the notification and returns the result
+	Send(ctx context.Context, ntf Notification) Result
+}
+
+//Resender is implemented by the notifiers that can send a notification again
+//to only some of its recipients (the Recipient of a failed Delivery)
+//e.g. when flushing the outbox
+type Resender interface {
+	Resend(ctx context.Context, ntf Notification, recipients []string) Result
+}
+
+//RecipientKeyer is implemented by the notifiers whose recipients are labels of their settings (see Delivery.Key)
+//RecipientKey returns the key that a recipient has in the current settings, "" if it has none
+//so that a delivery queued with another key (e.g. a webhook url since removed) is not sent to another url
+type RecipientKeyer interface {
+	RecipientKey(recipient string) string
+}
+
+//Factory builds a Notifier from its settings in the config file
+type Factory func(cfg parsers.NotifierConfig) (Notifier, consts.ERR)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+//Register makes a notifier type available for the config file
+//it is usually called in the init function of the package implementing the notifier
+//and panics if the same type is registered twice
+func Register(typ string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	typ = strings.ToLower(typ)
+	if factory == nil {
+		panic("registry: Register factory is nil for type " + typ)
+	}
+	if _, dup := factories[typ]; dup {
+		panic("registry: Register called twice for type " + typ)
+	}
+	factories[typ] = factory
+}
+
+//Types returns all the registered notifier types in alphabetical order
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	types := make([]string, 0, len(factories))
+	for typ := range factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+//lookup returns the factory registered for a notifier type
+func lookup(typ string) (Factory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	factory, ok := factories[strings.ToLower(typ)]
+	return factory, ok
+}
+
+//New builds a Notifier with the factory registered for the type of cfg
+func New(cfg parsers.NotifierConfig) (Notifier, consts.ERR) {
+	factory, ok := lookup(cfg.Type)
+	if !ok {
+		log.Println("unknown type \""+cfg.Type+"\" of notifier", cfg.Name)
+		return nil, consts.NOTIFRC_PARSE_ERR
+	}
+	return factory(cfg)
+}
+
+//Enabled builds all the notifiers whose state is on, in alphabetical order of their names
+//notifiers of an unregistered type are ignored
+func Enabled(ntfs parsers.Notifiers) ([]Notifier, consts.ERR) {
+	var enabled []Notifier
+	for _, name := range ntfs.Names() {
+		cfg := ntfs[name]
+		if !cfg.State {
+			continue
+		}
+		if _, ok := lookup(cfg.Type); !ok {
+			log.Println("unknown type \""+cfg.Type+"\" of notifier", name, "ignored")
+			continue
+		}
+		ntf, err := New(cfg)
+		if err != consts.NIL {
+			return nil, err
+		}
+		enabled = append(enabled, ntf)
+	}
+	return enabled, consts.NIL
+}
@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"notifier/parsers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//default delays used when the retry policy of a notifier doesn't set them
+const (
+	defaultBaseDelay = time.Second
+	defaultMaxDelay  = 30 * time.Second
+)
+
+//backoff returns the delay before the given retry(1, 2, 3...)
+//BaseDelay * 2^(retry-1), at most MaxDelay, randomized by Jitter
+func backoff(policy parsers.RetryPolicy, retry int) time.Duration {
+	base, max := policy.BaseDelay, policy.MaxDelay
+	if base <= 0 {
+		base = defaultBaseDelay
+	}
+	if max <= 0 {
+		max = defaultMaxDelay
+	}
+	delay := base
+	for i := 1; i < retry && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if policy.Jitter > 0 {
+		jitter := policy.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		//scale the delay by a random factor in [1-jitter, 1+jitter)
+		delay = time.Duration(float64(delay) * (1 + jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+//ParseRetryAfter parses the Retry-After header of an HTTP response
+//which is either a number of seconds or an HTTP date
+//returns 0 if the header is empty or invalid
+func ParseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+//maxErrBody is the number of bytes of a response body kept in the detail of a failed delivery
+const maxErrBody = 200
+
+//HTTPError describes a failed HTTP response with its status and the beginning of its body
+//e.g. "HTTP 404 Not Found: channel_not_found", to be used as the detail of a delivery
+func HTTPError(resp *http.Response) error {
+	msg := "HTTP " + resp.Status
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrBody+1))
+	if text := strings.Join(strings.Fields(string(body)), " "); text != "" {
+		if len(text) > maxErrBody {
+			text = text[:maxErrBody] + "..."
+		}
+		msg += ": " + text
+	}
+	return errors.New(msg)
+}
+
+//URLError removes the url from an error of net/http (a *url.Error), which may be a secret such as a webhook url
+//e.g. `Post "https://hooks.slack.com/...": dial tcp: ...` becomes "Post: dial tcp: ..."
+func URLError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return errors.New(urlErr.Op + ": " + urlErr.Err.Error())
+	}
+	return err
+}
+
+//Retry sends again to the recipients whose delivery failed with a temporary ERR
+//deliveries are the results of the first attempt, send delivers to the given recipients once
+//it waits for an exponential backoff (or the Retry-After of the server if longer) before each retry
+//until all deliveries succeed or fail permanently, policy.MaxAttempts is reached or ctx is done
+func Retry(ctx context.Context, policy parsers.RetryPolicy, deliveries []Delivery, send func(recipients []string) []Delivery) []Delivery {
+	for attempt := 2; attempt <= policy.MaxAttempts; attempt++ {
+		var (
+			recipients []string
+			retryAfter time.Duration
+		)
+		for _, dlv := range deliveries {
+			if dlv.Err.Retryable() {
+				recipients = append(recipients, dlv.Recipient)
+				if dlv.RetryAfter > retryAfter {
+					retryAfter = dlv.RetryAfter
+				}
+			}
+		}
+		if len(recipients) == 0 {
+			break
+		}
+
+		delay := backoff(policy, attempt-1)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		log.Printf("retrying %d recipient(s) in %v (attempt %d of %d)\n", len(recipients), delay, attempt, policy.MaxAttempts)
+		select {
+		case <-ctx.Done():
+			log.Println(ctx.Err())
+			return deliveries
+		case <-time.After(delay):
+		}
+
+		//replace the failed deliveries with the new ones
+		sent := send(recipients)
+		retried := make(map[string]Delivery)
+		for i := range sent {
+			sent[i].Attempts = attempt
+			retried[sent[i].Recipient] = sent[i]
+		}
+		known := make(map[string]bool)
+		for i, dlv := range deliveries {
+			known[dlv.Recipient] = true
+			if newDlv, ok := retried[dlv.Recipient]; ok && dlv.Err.Retryable() {
+				deliveries[i] = newDlv
+			}
+		}
+		//a retry may report more outcomes than the failed ones
+		//e.g. the files uploaded after a slack message which was not posted at the first attempt
+		for _, dlv := range sent {
+			if !known[dlv.Recipient] {
+				known[dlv.Recipient] = true
+				deliveries = append(deliveries, dlv)
+			}
+		}
+	}
+	return deliveries
+}
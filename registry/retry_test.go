@@ -0,0 +1,186 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"notifier/consts"
+	"notifier/parsers"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy parsers.RetryPolicy
+		retry  int
+		want   time.Duration
+	}{
+		{"defaults first", parsers.RetryPolicy{}, 1, defaultBaseDelay},
+		{"defaults third", parsers.RetryPolicy{}, 3, 4 * defaultBaseDelay},
+		{"defaults capped", parsers.RetryPolicy{}, 10, defaultMaxDelay},
+		{"base", parsers.RetryPolicy{BaseDelay: 2 * time.Second}, 1, 2 * time.Second},
+		{"doubled", parsers.RetryPolicy{BaseDelay: 2 * time.Second}, 4, 16 * time.Second},
+		{"capped", parsers.RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}, 4, 5 * time.Second},
+		{"base over max", parsers.RetryPolicy{BaseDelay: time.Minute, MaxDelay: 10 * time.Second}, 1, 10 * time.Second},
+		{"many retries", parsers.RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Hour}, 1000, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backoff(tt.policy, tt.retry); got != tt.want {
+				t.Errorf("backoff(%+v, %d) = %v, want %v", tt.policy, tt.retry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	tests := []struct {
+		jitter   float64
+		min, max time.Duration
+	}{
+		{0.5, 2 * time.Second, 6 * time.Second},
+		//a jitter over 1 is taken as 1
+		{3, 0, 8 * time.Second},
+	}
+	for _, tt := range tests {
+		policy := parsers.RetryPolicy{BaseDelay: time.Second, Jitter: tt.jitter}
+		for i := 0; i < 100; i++ {
+			if got := backoff(policy, 3); got < tt.min || got >= tt.max {
+				t.Fatalf("backoff with jitter %v = %v, want in [%v, %v)", tt.jitter, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name     string
+		header   string
+		min, max time.Duration
+	}{
+		{"empty", "", 0, 0},
+		{"seconds", "120", 120 * time.Second, 120 * time.Second},
+		{"zero", "0", 0, 0},
+		{"negative", "-5", 0, 0},
+		{"invalid", "soon", 0, 0},
+		{"fraction", "1.5", 0, 0},
+		//an HTTP date has a precision of one second
+		{"date", now.Add(90 * time.Second).Format(http.TimeFormat), 88 * time.Second, 90 * time.Second},
+		{"past date", now.Add(-time.Hour).Format(http.TimeFormat), 0, 0},
+		{"rfc850 date", now.Add(time.Hour).Format(time.RFC850), 58 * time.Minute, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseRetryAfter(tt.header); got < tt.min || got > tt.max {
+				t.Errorf("ParseRetryAfter(%q) = %v, want in [%v, %v]", tt.header, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestURLError(t *testing.T) {
+	secret := "https://hooks.slack.com/services/T000/B000/XXXX"
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"url error", &url.Error{Op: "Post", URL: secret, Err: errors.New("dial tcp: connection refused")}, "Post: dial tcp: connection refused"},
+		{"other error", errors.New("boom"), "boom"},
+	}
+	for _, tt := range tests {
+		if got := URLError(tt.err).Error(); got != tt.want {
+			t.Errorf("URLError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := parsers.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	tests := []struct {
+		name     string
+		first    []Delivery
+		results  map[string][]consts.ERR //outcome of each retry of a recipient
+		want     map[string]consts.ERR
+		attempts map[string]int
+	}{
+		{
+			name:     "all sent",
+			first:    []Delivery{NewDelivery("a", consts.NIL, nil)},
+			want:     map[string]consts.ERR{"a": consts.NIL},
+			attempts: map[string]int{"a": 1},
+		},
+		{
+			name:     "sent at the second attempt",
+			first:    []Delivery{NewDelivery("a", consts.REQ_FAIL, nil), NewDelivery("b", consts.NIL, nil)},
+			results:  map[string][]consts.ERR{"a": {consts.NIL}},
+			want:     map[string]consts.ERR{"a": consts.NIL, "b": consts.NIL},
+			attempts: map[string]int{"a": 2, "b": 1},
+		},
+		{
+			name:     "permanent error not retried",
+			first:    []Delivery{NewDelivery("a", consts.CHL_NOT_FOUND, nil)},
+			want:     map[string]consts.ERR{"a": consts.CHL_NOT_FOUND},
+			attempts: map[string]int{"a": 1},
+		},
+		{
+			name:     "max attempts",
+			first:    []Delivery{NewDelivery("a", consts.RATE_LIMITED, nil)},
+			results:  map[string][]consts.ERR{"a": {consts.RATE_LIMITED, consts.RATE_LIMITED, consts.NIL}},
+			want:     map[string]consts.ERR{"a": consts.RATE_LIMITED},
+			attempts: map[string]int{"a": 3},
+		},
+		{
+			name:     "permanent error at a retry",
+			first:    []Delivery{NewDelivery("a", consts.ROLLUP_ERROR, nil)},
+			results:  map[string][]consts.ERR{"a": {consts.INVALID_PAYLOAD, consts.NIL}},
+			want:     map[string]consts.ERR{"a": consts.INVALID_PAYLOAD},
+			attempts: map[string]int{"a": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := make(map[string]int)
+			send := func(recipients []string) []Delivery {
+				var deliveries []Delivery
+				for _, r := range recipients {
+					results := tt.results[r]
+					if sent[r] >= len(results) {
+						t.Fatalf("unexpected retry of %s", r)
+					}
+					deliveries = append(deliveries, NewDelivery(r, results[sent[r]], nil))
+					sent[r]++
+				}
+				return deliveries
+			}
+			got := Retry(context.Background(), policy, tt.first, send)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Retry returned %d deliveries, want %d", len(got), len(tt.want))
+			}
+			for _, dlv := range got {
+				if dlv.Err != tt.want[dlv.Recipient] || dlv.Attempts != tt.attempts[dlv.Recipient] {
+					t.Errorf("delivery to %s: err %v after %d attempt(s), want %v after %d", dlv.Recipient,
+						dlv.Err, dlv.Attempts, tt.want[dlv.Recipient], tt.attempts[dlv.Recipient])
+				}
+			}
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	policy := parsers.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	first := []Delivery{NewDelivery("a", consts.REQ_FAIL, nil)}
+	got := Retry(ctx, policy, first, func(recipients []string) []Delivery {
+		t.Fatal("sent after the context is done")
+		return nil
+	})
+	if len(got) != 1 || got[0].Err != consts.REQ_FAIL {
+		t.Errorf("Retry = %+v, want the first delivery", got)
+	}
+}
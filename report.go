@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"notifier/parsers"
+	"notifier/registry"
+)
+
+//printReport prints the delivery result of each recipient as a table
+//nothing is printed if there is no delivery at all
+func printReport(results []registry.Result) {
+	count := 0
+	for _, res := range results {
+		count += len(res.Deliveries)
+	}
+	if count == 0 {
+		return
+	}
+	w := tabwriter.NewWriter(parsers.RedactWriter(os.Stdout), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NOTIFIER\tTYPE\tRECIPIENT\tSTATUS\tCODE\tATTEMPTS\tTIME\tERROR")
+	for _, res := range results {
+		for _, dlv := range res.Deliveries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+				dlv.Notifier, dlv.Type, dlv.Recipient, dlv.Status(), dlv.Err, dlv.Attempts,
+				dlv.Time.Format(time.RFC3339), dlv.Detail)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"notifier/consts"
+	"notifier/parsers"
+	slk "notifier/slackNotify"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+)
+
+//SlackListOptions are the options of the commands listing slack channels and users
+type SlackListOptions struct {
+	Notifier string   //name of the slack notifier in notifyrcFile whose token is used
+	IDs      []string //only the channels/users with these IDs, all if empty
+	Filter   string   //only the channels/users whose ID, name or email contains it
+	Limit    int      //at most Limit channels/users are printed (and saved), all if 0
+	JSON     bool     //print a JSON array instead of a table
+	Save     bool     //add the IDs listed to the default slackListFile
+}
+
+//SlackList prints the channels or users (users is true) visible to the token of a slack notifier, or those of opts.IDs
+//and adds their IDs to the default slackListFile if opts.Save is set
+func SlackList(users bool, opts SlackListOptions) error {
+	ntfs, err := parsers.ParseNotifiers(parsers.NotifyrcFile)
+	if err != consts.NIL {
+		return cli.NewExitError("", int(err))
+	}
+	if ntfs, err = ntfs.Select([]string{opts.Notifier}); err != consts.NIL {
+		return cli.NewExitError("", int(err))
+	}
+	cfg := ntfs[strings.ToLower(opts.Notifier)]
+
+	var listings []slk.Listing
+	if users {
+		listings, err = slk.ListUsers(context.Background(), cfg)
+	} else {
+		listings, err = slk.ListChannels(context.Background(), cfg)
+	}
+	if err != consts.NIL {
+		return cli.NewExitError("", int(err))
+	}
+
+	ids := make(map[string]bool, len(opts.IDs))
+	for _, id := range opts.IDs {
+		ids[id] = true
+	}
+	selected := make([]slk.Listing, 0, len(listings))
+	for _, l := range listings {
+		if len(ids) > 0 && !ids[l.ID] {
+			continue
+		}
+		delete(ids, l.ID)
+		if opts.Filter == "" || l.Match(opts.Filter) {
+			selected = append(selected, l)
+		}
+	}
+	for id := range ids {
+		log.Println(id, "is not visible to the token of", opts.Notifier)
+	}
+	if opts.Limit > 0 && len(selected) > opts.Limit {
+		selected = selected[:opts.Limit]
+	}
+
+	if opts.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(selected); err != nil {
+			log.Println(err)
+			return cli.NewExitError("", int(consts.GENERAL_ERR))
+		}
+	} else {
+		printListings(users, selected)
+	}
+
+	if opts.Save {
+		if err := saveSlackIDs(selected); err != nil {
+			return cli.NewExitError(err.Error(), int(consts.DFLTS_PARSE_ERR))
+		}
+	}
+	return nil
+}
+
+//printListings prints channels or users as a table
+func printListings(users bool, listings []slk.Listing) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if users {
+		fmt.Fprintln(w, "ID\tNAME\tREAL NAME\tDISPLAY NAME\tEMAIL\tBOT")
+	} else {
+		fmt.Fprintln(w, "ID\tNAME\tPRIVATE\tMEMBERS\tTOPIC")
+	}
+	for _, l := range listings {
+		if users {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", l.ID, l.Name, l.RealName, l.DisplayName, l.Email, yesNo(l.Bot))
+		} else {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.ID, l.Name, yesNo(l.Private), strconv.Itoa(l.Members), oneLine(l.Topic))
+		}
+	}
+	w.Flush()
+	fmt.Println(len(listings), "found")
+}
+
+//yesNo formats a boolean column of the table
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+//oneLine keeps a text on one line of the table, at most 60 characters
+func oneLine(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > 60 {
+		text = string(runes[:57]) + "..."
+	}
+	return text
+}
+
+//saveSlackIDs adds the IDs of listings to the default slackListFile (one ID per line)
+//the IDs already in the file are kept and never added twice, the file is replaced atomically
+func saveSlackIDs(listings []slk.Listing) error {
+	dflt, err := parsers.ParseDefaults(parsers.DefaultsFile)
+	if err != consts.NIL {
+		return errors.New("cannot parse the default settings")
+	}
+	if dflt.SlackListFile == "" {
+		return errors.New("no slackListFile in the default settings, set it with: notifier setdefault slackListFile FILE")
+	}
+	ids := dflt.GetDfltSlackList()
+	known := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
+	}
+	added := 0
+	for _, l := range listings {
+		if !known[l.ID] {
+			ids = append(ids, l.ID)
+			known[l.ID] = true
+			added++
+		}
+	}
+	if added == 0 {
+		log.Println("no new ID to add to", dflt.SlackListFile)
+		return nil
+	}
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(dflt.SlackListFile); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := parsers.WriteAtomic(dflt.SlackListFile, []byte(strings.Join(ids, "\n")+"\n"), perm); err != nil {
+		return err
+	}
+	log.Println(added, "ID(s) added to", dflt.SlackListFile)
+	return nil
+}
@@ -0,0 +1,282 @@
+package slackNotify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"notifier/consts"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+//Block is a Block Kit layout block, e.g. {"type": "divider"}
+//it is a plain map, so that the blocks of a template file are sent as they are
+//https://api.slack.com/reference/block-kit/blocks
+type Block = map[string]interface{}
+
+//BlockSpec describes the Block Kit message to compose, see ComposeBlocks
+type BlockSpec struct {
+	Template string   //YAML/JSON file of blocks, whose strings are Go templates rendered with BlockData
+	Subject  string   //text of the header block
+	Message  string   //text of the section block following the header
+	Fields   []string //"Name=Value" pairs shown as the fields of a section block
+	Context  []string //texts of a context block at the bottom of the message
+	CodeFile string   //file whose end (e.g. of a log) is shown in a code block
+}
+
+//BlockData is the data that the strings of a blocks template are rendered with
+//e.g. {"type": "section", "text": {"type": "mrkdwn", "text": "*{{.Subject}}*"}}
+type BlockData struct {
+	Subject string
+	Message string
+	Fields  map[string]string
+	Context []string
+	Code    string //end of the CodeFile
+}
+
+//Blocks tells if spec composes a Block Kit message rather than a plain one
+func (spec BlockSpec) Blocks() bool {
+	return spec.Template != "" || len(spec.Fields) > 0 || len(spec.Context) > 0 || spec.CodeFile != ""
+}
+
+//ComposeBlocks composes a Block Kit message from a template file or from the other settings of spec
+//without a template, the message is a header (subject), a section (message), a section of fields,
+//a divider and a code block (if CodeFile is set) and a context block
+//with a template, the message is made of the blocks of the template only, the other settings are its data
+//no blocks are returned if spec does not compose a Block Kit message (see BlockSpec.Blocks)
+func ComposeBlocks(spec BlockSpec) ([]Block, consts.ERR) {
+	if !spec.Blocks() {
+		return nil, consts.NIL
+	}
+	data := BlockData{Subject: spec.Subject, Message: spec.Message, Fields: map[string]string{}, Context: spec.Context}
+	fields := make([][2]string, 0, len(spec.Fields))
+	for _, f := range spec.Fields {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			log.Println("invalid field", f, ", expected Name=Value")
+			return nil, consts.BLOCKS_ERR
+		}
+		fields = append(fields, [2]string{strings.TrimSpace(kv[0]), kv[1]})
+		data.Fields[strings.TrimSpace(kv[0])] = kv[1]
+	}
+	if spec.CodeFile != "" {
+		code, err := ioutil.ReadFile(spec.CodeFile)
+		if err != nil {
+			log.Println(err)
+			return nil, consts.BLOCKS_ERR
+		}
+		//the fences are escaped before the tail is cut, since escaping lengthens the text
+		data.Code = tail(escapeFences(string(code)), consts.MAX_SLACK_TEXT_LEN-len("```\n\n```"))
+	}
+
+	var blocks []Block
+	if spec.Template != "" {
+		var err error
+		if blocks, err = loadBlocksTemplate(spec.Template, data); err != nil {
+			log.Println("cannot compose the slack blocks from", spec.Template, ":", err)
+			return nil, consts.BLOCKS_ERR
+		}
+	} else {
+		blocks = defaultBlocks(spec, fields, data.Code)
+	}
+	if len(blocks) > consts.MAX_SLACK_BLOCKS {
+		log.Println("a slack message can have at most", consts.MAX_SLACK_BLOCKS, "blocks, got", len(blocks))
+		return nil, consts.BLOCKS_ERR
+	}
+	return blocks, consts.NIL
+}
+
+//defaultBlocks composes the blocks of a message without a template
+func defaultBlocks(spec BlockSpec, fields [][2]string, code string) []Block {
+	var blocks []Block
+	if spec.Subject != "" {
+		blocks = append(blocks, HeaderBlock(spec.Subject))
+	}
+	if strings.TrimSpace(spec.Message) != "" {
+		blocks = append(blocks, SectionBlock(spec.Message))
+	}
+	if len(fields) > 0 {
+		blocks = append(blocks, FieldsBlocks(fields)...)
+	}
+	if code != "" {
+		blocks = append(blocks, DividerBlock(), CodeBlock(code))
+	}
+	if len(spec.Context) > 0 {
+		blocks = append(blocks, ContextBlock(spec.Context...))
+	}
+	return blocks
+}
+
+//HeaderBlock returns a header block, the text is truncated to the limit of slack
+func HeaderBlock(text string) Block {
+	return Block{"type": "header", "text": plainText(truncate(text, consts.MAX_SLACK_HEADER_LEN))}
+}
+
+//SectionBlock returns a section block with a mrkdwn text, truncated to the limit of slack
+func SectionBlock(text string) Block {
+	return Block{"type": "section", "text": mrkdwn(truncate(text, consts.MAX_SLACK_TEXT_LEN))}
+}
+
+//FieldsBlocks returns the section blocks showing the fields as "*Name*\nValue" in two columns
+//a new section block is started every MAX_SLACK_FIELDS fields
+func FieldsBlocks(fields [][2]string) []Block {
+	var blocks []Block
+	for len(fields) > 0 {
+		n := len(fields)
+		if n > consts.MAX_SLACK_FIELDS {
+			n = consts.MAX_SLACK_FIELDS
+		}
+		texts := make([]interface{}, 0, n)
+		for _, f := range fields[:n] {
+			texts = append(texts, mrkdwn(truncate("*"+f[0]+"*\n"+f[1], consts.MAX_SLACK_FIELD_LEN)))
+		}
+		blocks = append(blocks, Block{"type": "section", "fields": texts})
+		fields = fields[n:]
+	}
+	return blocks
+}
+
+//DividerBlock returns a divider block
+func DividerBlock() Block {
+	return Block{"type": "divider"}
+}
+
+//CodeBlock returns a section block showing text as preformatted code (e.g. a log excerpt)
+//the end of text is kept if the code block would be longer than slack allows (see tail)
+func CodeBlock(text string) Block {
+	text = tail(strings.TrimRight(escapeFences(text), "\n"), consts.MAX_SLACK_TEXT_LEN-len("```\n\n```"))
+	return Block{"type": "section", "text": mrkdwn("```\n" + text + "\n```")}
+}
+
+//escapeFences breaks the ``` in text, which would end a code block early
+func escapeFences(text string) string {
+	return strings.Replace(text, "```", "` ` `", -1)
+}
+
+//ContextBlock returns a context block with a mrkdwn element for each text
+func ContextBlock(texts ...string) Block {
+	elements := make([]interface{}, 0, len(texts))
+	for _, text := range texts {
+		elements = append(elements, mrkdwn(text))
+	}
+	return Block{"type": "context", "elements": elements}
+}
+
+//plainText and mrkdwn return the text objects of blocks
+func plainText(text string) map[string]interface{} {
+	return map[string]interface{}{"type": "plain_text", "text": text, "emoji": true}
+}
+
+func mrkdwn(text string) map[string]interface{} {
+	return map[string]interface{}{"type": "mrkdwn", "text": text}
+}
+
+//truncate cuts text to at most max characters, ending with "..." if it is cut
+func truncate(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:max-3]) + "..."
+}
+
+//tail keeps the last max characters of text (e.g. the last lines of a log), starting at a line if possible
+func tail(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	text = string(runes[len(runes)-max+len("...\n"):])
+	if i := strings.Index(text, "\n"); i >= 0 && i < len(text)-1 {
+		text = text[i+1:]
+	}
+	return "...\n" + text
+}
+
+//loadBlocksTemplate reads the blocks of a YAML/JSON template file and renders their strings with data
+//the file is either a list of blocks or a mapping with a "blocks" list (as the payload of slack)
+func loadBlocksTemplate(path string, data BlockData) ([]Block, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if m, ok := doc.(map[string]interface{}); ok {
+		doc = m["blocks"]
+	}
+	list, ok := doc.([]interface{})
+	if !ok {
+		return nil, errors.New("expected a list of blocks, or a mapping with a \"blocks\" list")
+	}
+
+	blocks := make([]Block, 0, len(list))
+	for i, item := range list {
+		block, ok := item.(map[string]interface{})
+		if !ok || block["type"] == nil {
+			return nil, fmt.Errorf("block %d is not a mapping with a type", i+1)
+		}
+		rendered, err := renderStrings(block, data, textLimit(block["type"]))
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %v", i+1, err)
+		}
+		blocks = append(blocks, rendered.(map[string]interface{}))
+	}
+	return blocks, nil
+}
+
+//textLimit returns the limit of slack on the texts of a block of type typ (e.g. 150 characters in a header)
+func textLimit(typ interface{}) int {
+	if typ == "header" {
+		return consts.MAX_SLACK_HEADER_LEN
+	}
+	return consts.MAX_SLACK_TEXT_LEN
+}
+
+//renderStrings renders every string in a value decoded from YAML as a Go template with data
+//the rendered texts are truncated to limit (see textLimit), or to the limit of slack on fields in "fields"
+func renderStrings(v interface{}, data BlockData, limit int) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		tmpl, err := template.New("block").Option("missingkey=zero").Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		return truncate(buf.String(), limit), nil
+	case map[string]interface{}:
+		for k, item := range v {
+			itemLimit := limit
+			if k == "fields" && consts.MAX_SLACK_FIELD_LEN < limit {
+				itemLimit = consts.MAX_SLACK_FIELD_LEN
+			}
+			rendered, err := renderStrings(item, data, itemLimit)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = rendered
+		}
+	case []interface{}:
+		for i, item := range v {
+			rendered, err := renderStrings(item, data, limit)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = rendered
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,133 @@
+package slackNotify
+
+import (
+	"io/ioutil"
+	"notifier/consts"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want string
+	}{
+		{"short", "hello", 10, "hello"},
+		{"exact", "hello", 5, "hello"},
+		{"cut", "hello world", 8, "hello..."},
+		{"runes", "ééééé", 4, "é..."},
+		{"empty", "", 3, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncate(tt.text, tt.max); got != tt.want {
+				t.Errorf("truncate(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTail(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want string
+	}{
+		{"short", "a\nb\n", 10, "a\nb\n"},
+		{"exact", "abcd", 4, "abcd"},
+		//the end is kept from the start of a line
+		{"lines", "line1\nline2\nline3\n", 14, "...\nline3\n"},
+		//a single long line is cut in the middle
+		{"one line", "0123456789", 8, "...\n6789"},
+		{"runes", "ééééééééé", 6, "...\néé"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tail(tt.text, tt.max)
+			if got != tt.want {
+				t.Errorf("tail(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+			}
+			if n := utf8.RuneCountInString(got); n > tt.max {
+				t.Errorf("tail(%q, %d) has %d characters", tt.text, tt.max, n)
+			}
+		})
+	}
+}
+
+//codeText returns the text of a code block
+func codeText(t *testing.T, block Block) string {
+	t.Helper()
+	text, ok := block["text"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("block %v has no text", block)
+	}
+	return text["text"].(string)
+}
+
+func TestCodeBlock(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string //the text of the block, checked if not empty
+	}{
+		{"plain", "error: boom\n", "```\nerror: boom\n```"},
+		{"fence", "a ``` b", "```\na ` ` ` b\n```"},
+		{"long", strings.Repeat("x\n", 2000), ""},
+		//each escaped fence is 2 characters longer
+		{"fences", strings.Repeat("```\n", 1000), ""},
+		{"long line of fences", strings.Repeat("```", 1000), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := codeText(t, CodeBlock(tt.text))
+			if tt.want != "" && got != tt.want {
+				t.Errorf("CodeBlock(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if n := utf8.RuneCountInString(got); n > consts.MAX_SLACK_TEXT_LEN {
+				t.Errorf("CodeBlock text has %d characters, more than %d", n, consts.MAX_SLACK_TEXT_LEN)
+			}
+			if strings.Count(got, "```") != 2 {
+				t.Errorf("CodeBlock text %q has unescaped fences", got)
+			}
+		})
+	}
+}
+
+func TestComposeBlocksCodeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		code string
+	}{
+		{"short", "done\n"},
+		{"long", strings.Repeat("some log line\n", 500)},
+		{"fences", strings.Repeat("```log```\n", 500)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".log")
+			if err := ioutil.WriteFile(path, []byte(tt.code), 0644); err != nil {
+				t.Fatal(err)
+			}
+			blocks, code := ComposeBlocks(BlockSpec{Subject: "build", CodeFile: path})
+			if code != consts.NIL {
+				t.Fatalf("ComposeBlocks returned %v", code)
+			}
+			last := blocks[len(blocks)-1]
+			if n := utf8.RuneCountInString(codeText(t, last)); n > consts.MAX_SLACK_TEXT_LEN {
+				t.Errorf("code block text has %d characters, more than %d", n, consts.MAX_SLACK_TEXT_LEN)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package slackNotify
+
+import (
+	"context"
+	"log"
+	"net"
+	"notifier/consts"
+	"notifier/parsers"
+	"sort"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+//Listing is a channel or a user visible to a slack token, see ListChannels and ListUsers
+type Listing struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"` //#channel or @user, as accepted as a recipient
+	RealName    string `json:"realName,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Bot         bool   `json:"bot,omitempty"`
+	Private     bool   `json:"private,omitempty"`
+	Members     int    `json:"members,omitempty"`
+	Topic       string `json:"topic,omitempty"`
+}
+
+//Match tells if the ID, a name or the email of l contains filter (case-insensitive)
+func (l Listing) Match(filter string) bool {
+	filter = strings.ToLower(filter)
+	for _, s := range []string{l.ID, l.Name, l.RealName, l.DisplayName, l.Email} {
+		if strings.Contains(strings.ToLower(s), filter) {
+			return true
+		}
+	}
+	return false
+}
+
+//ListChannels lists the public and private channels (not archived) visible to the token of a slack notifier
+//sorted by name, all the pages of conversations.list are fetched
+func ListChannels(ctx context.Context, cfg parsers.NotifierConfig) ([]Listing, consts.ERR) {
+	api, err := listAPI(cfg)
+	if err != consts.NIL {
+		return nil, err
+	}
+	channels, lerr := getSlackChannels(ctx, api)
+	if lerr != nil {
+		return nil, listErr(lerr)
+	}
+	listings := make([]Listing, 0, len(channels))
+	for _, ch := range channels {
+		topic := ch.Topic.Value
+		if topic == "" {
+			topic = ch.Purpose.Value
+		}
+		listings = append(listings, Listing{
+			ID:      ch.ID,
+			Name:    "#" + ch.Name,
+			Private: ch.IsPrivate,
+			Members: ch.NumMembers,
+			Topic:   topic,
+		})
+	}
+	sortListings(listings)
+	return listings, consts.NIL
+}
+
+//ListUsers lists the users (not deleted) visible to the token of a slack notifier
+//sorted by name, all the pages of users.list are fetched
+func ListUsers(ctx context.Context, cfg parsers.NotifierConfig) ([]Listing, consts.ERR) {
+	api, err := listAPI(cfg)
+	if err != consts.NIL {
+		return nil, err
+	}
+	users, lerr := getSlackUsers(ctx, api)
+	if lerr != nil {
+		return nil, listErr(lerr)
+	}
+	listings := make([]Listing, 0, len(users))
+	for _, u := range users {
+		if u.Deleted {
+			continue
+		}
+		listings = append(listings, Listing{
+			ID:          u.ID,
+			Name:        "@" + u.Name,
+			RealName:    u.RealName,
+			DisplayName: u.Profile.DisplayName,
+			Email:       u.Profile.Email,
+			Bot:         u.IsBot,
+		})
+	}
+	sortListings(listings)
+	return listings, consts.NIL
+}
+
+//listAPI returns a slack client with the token of a notifier of type "slack" whose state is on
+func listAPI(cfg parsers.NotifierConfig) (*slack.Client, consts.ERR) {
+	var ntf parsers.SlackNotifier
+	if err := cfg.Decode(&ntf); err != nil {
+		log.Println(err)
+		return nil, consts.NOTIFRC_PARSE_ERR
+	}
+	token := getToken(ntf)
+	if token == "" {
+		log.Println("notifier", cfg.Name, "is not of type", consts.SlackType, "with a token and state on")
+		return nil, consts.SLK_TOKEN_INVAL
+	}
+	return slack.New(token), consts.NIL
+}
+
+//listErr logs the error of a list request and returns its ERR
+func listErr(err error) consts.ERR {
+	log.Println(err)
+	if strings.Contains(err.Error(), "auth") {
+		log.Println("Your slack token is invalid, please check that.")
+		return consts.SLK_TOKEN_INVAL
+	}
+	if _, ok := err.(net.Error); ok {
+		log.Println("You may lose Internet connection or be refused by remote host.")
+	}
+	return consts.SLK_SVR_CONN_ERR
+}
+
+//sortListings sorts listings by name
+func sortListings(listings []Listing) {
+	sort.Slice(listings, func(i, j int) bool {
+		return strings.ToLower(listings[i].Name) < strings.ToLower(listings[j].Name)
+	})
+}
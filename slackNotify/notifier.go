@@ -0,0 +1,125 @@
+package slackNotify
+
+import (
+	"context"
+	"log"
+	"notifier/consts"
+	"notifier/parsers"
+	"notifier/registry"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+func init() {
+	registry.Register(consts.SlackType, newSlackNotifier)
+	registry.Register(consts.SlackWebhookType, newSlackNotifier)
+}
+
+//slackNotifier is the registry.Notifier of type "slack" and "slackWebhook"
+type slackNotifier struct {
+	name  string
+	ntf   parsers.SlackNotifier
+	retry parsers.RetryPolicy
+}
+
+//newSlackNotifier builds a slackNotifier from its settings in notifyrcFile
+func newSlackNotifier(cfg parsers.NotifierConfig) (registry.Notifier, consts.ERR) {
+	n := &slackNotifier{name: cfg.Name, retry: cfg.Retry}
+	if err := cfg.Decode(&n.ntf); err != nil {
+		log.Println(err)
+		return nil, consts.NOTIFRC_PARSE_ERR
+	}
+	return n, consts.NIL
+}
+
+//Name returns the name of the notifier in notifyrcFile
+func (n *slackNotifier) Name() string {
+	return n.name
+}
+
+//Validate checks the notification type "slack" or "slackWebhook" and if the state is "on"
+func (n *slackNotifier) Validate() consts.ERR {
+	switch strings.ToLower(n.ntf.Type) {
+	case consts.SlackType, strings.ToLower(consts.SlackWebhookType):
+		if n.ntf.State {
+			return consts.NIL
+		}
+	}
+	return consts.SLK_INVAL
+}
+
+//Send posts the notification to its slack recipients
+//the recipients failed with a temporary error are retried according to the retry policy
+func (n *slackNotifier) Send(ctx context.Context, ntf registry.Notification) registry.Result {
+	to := ntf.To(consts.SlackRecipients)
+	deliveries, err := SlackNotify(ctx, to, ntf.Subject, ntf.Message, ntf.Blocks, ntf.Attachments, n.settings(ntf))
+	if len(deliveries) > 0 {
+		deliveries = registry.Retry(ctx, n.retry, deliveries, func(recipients []string) []registry.Delivery {
+			return n.resend(ctx, recipients, len(to) > 0, ntf)
+		})
+		err = registry.FirstErr(deliveries)
+	}
+	return registry.NewResult(n.name, n.ntf.Type, deliveries, err)
+}
+
+//Resend posts the notification to some of the recipients of Send only
+//which are slack IDs, or webhooks (see webhookRecipients) if the notification has no slack IDs and the type is "slackWebhook"
+func (n *slackNotifier) Resend(ctx context.Context, ntf registry.Notification, recipients []string) registry.Result {
+	hasIDs := len(ntf.To(consts.SlackRecipients)) > 0
+	deliveries := n.resend(ctx, recipients, hasIDs, ntf)
+	deliveries = registry.Retry(ctx, n.retry, deliveries, func(recipients []string) []registry.Delivery {
+		return n.resend(ctx, recipients, hasIDs, ntf)
+	})
+	return registry.NewResult(n.name, n.ntf.Type, deliveries, registry.FirstErr(deliveries))
+}
+
+//RecipientKey returns the key of a recipient in the current settings (see registry.RecipientKeyer)
+//the hash of the webhook url it is posted to with type "slackWebhook", "" with a token
+func (n *slackNotifier) RecipientKey(recipient string) string {
+	if !strings.EqualFold(n.ntf.Type, consts.SlackWebhookType) {
+		return ""
+	}
+	for i, label := range webhookRecipients(n.ntf.WebhookURLs) {
+		if label == recipient {
+			return registry.URLKey(n.ntf.WebhookURLs[i])
+		}
+	}
+	//a channel posted to through the only webhook url
+	if len(n.ntf.WebhookURLs) == 1 {
+		return registry.URLKey(n.ntf.WebhookURLs[0])
+	}
+	return ""
+}
+
+//resend posts the notification again to some of the recipients of SlackNotify
+//which are slack IDs, or webhooks (see webhookRecipients) if the notification has no slack IDs and the type is "slackWebhook"
+func (n *slackNotifier) resend(ctx context.Context, recipients []string, hasIDs bool, ntf registry.Notification) []registry.Delivery {
+	settings := n.settings(ntf)
+	if strings.ToLower(n.ntf.Type) == consts.SlackType {
+		return postMsgUsers(ctx, settings, recipients, ntf.Subject, slack.Attachment{Text: ntf.Message}, ntf.Blocks, ntf.Attachments)
+	}
+	payload := buildPayload(ntf.Subject, ntf.Message, ntf.Blocks, settings)
+	if len(n.ntf.WebhookURLs) == 1 && hasIDs {
+		return postMsgWebhookWithChannels(ctx, n.ntf.WebhookURLs[0], recipients, payload)
+	}
+	return postMsgWebhooks(ctx, n.ntf.WebhookURLs, recipients, payload)
+}
+
+//levelColors are the attachment colors of the levels, replacing the color of the settings
+var levelColors = map[consts.Level]string{
+	consts.LevelInfo:     "#439FE0",
+	consts.LevelWarning:  "warning",
+	consts.LevelError:    "danger",
+	consts.LevelCritical: "#8B0000",
+}
+
+//settings returns the settings of the notifier for a notification
+//with the color of its level if a level was given
+func (n *slackNotifier) settings(ntf registry.Notification) parsers.SlackNotifier {
+	settings := n.ntf
+	if color, ok := levelColors[ntf.Level]; ok {
+		settings.Color = color
+	}
+	return settings
+}
@@ -0,0 +1,273 @@
+package slackNotify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"notifier/consts"
+	"notifier/parsers"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+//slack recipients can be given as IDs or as:
+//  @name           the user name, display name or real name of a user (users.list)
+//  name@corp.com   the email address of a user (users.lookupByEmail)
+//  #channel        the name of a public or private channel (conversations.list)
+//the resolved IDs are cached in $HOME/consts.SlackCacheFile, per token
+
+//slackIDRe matches the IDs of users (U, W), channels (C, G) and direct messages (D)
+var slackIDRe = regexp.MustCompile(`^[UWCGD][A-Z0-9]{6,}$`)
+
+//isSlackID tells if a recipient is already an ID, which is used as it is
+func isSlackID(recipient string) bool {
+	return slackIDRe.MatchString(recipient)
+}
+
+//recipientErr is the error of a recipient which cannot be resolved, with the ERR of its delivery
+type recipientErr struct {
+	code consts.ERR
+	err  error
+}
+
+func (e *recipientErr) Error() string {
+	return e.err.Error()
+}
+
+//resolver resolves the recipients of one token
+//the lists of users and channels are fetched once, when a name is not in the cache
+type resolver struct {
+	api      *slack.Client
+	cache    *idCache
+	users    []slack.User
+	channels []slack.Channel
+}
+
+//newResolver returns a resolver using the cache of token
+func newResolver(api *slack.Client, token string) *resolver {
+	return &resolver{api: api, cache: loadIDCache(token)}
+}
+
+//resolve returns the ID of a recipient, looking it up if it is not cached
+//a *recipientErr is returned if the recipient is unknown or ambiguous, or if the lookup failed
+func (r *resolver) resolve(ctx context.Context, recipient string) (string, error) {
+	if isSlackID(recipient) {
+		return recipient, nil
+	}
+	if id, ok := r.cache.get(recipient); ok {
+		return id, nil
+	}
+
+	var id string
+	var err error
+	switch {
+	case strings.HasPrefix(recipient, "#"):
+		id, err = r.channelID(ctx, strings.TrimPrefix(recipient, "#"))
+	case strings.HasPrefix(recipient, "@"):
+		id, err = r.userID(ctx, strings.TrimPrefix(recipient, "@"))
+	case strings.Contains(recipient, "@"):
+		id, err = r.emailID(ctx, recipient)
+	default:
+		err = &recipientErr{consts.SLK_RCPT_ERR, errors.New("\"" + recipient + "\" is not a slack ID, @name, email or #channel")}
+	}
+	if err != nil {
+		return "", err
+	}
+	r.cache.set(recipient, id)
+	return id, nil
+}
+
+//lookupErr converts the error of a slack lookup into a *recipientErr
+func lookupErr(recipient string, err error) error {
+	switch {
+	case strings.Contains(err.Error(), "users_not_found"):
+		return &recipientErr{consts.SLK_RCPT_ERR, errors.New("no slack user with the email " + recipient)}
+	case strings.Contains(err.Error(), "auth"):
+		return &recipientErr{consts.SLK_TOKEN_INVAL, err}
+	}
+	if _, ok := err.(net.Error); ok {
+		return &recipientErr{consts.SLK_SVR_CONN_ERR, err}
+	}
+	if _, ok := err.(*slack.RateLimitedError); ok {
+		return &recipientErr{consts.SLK_SVR_CONN_ERR, err}
+	}
+	return &recipientErr{consts.SLK_CHL_ERR, err}
+}
+
+//emailID looks up a user by email address
+func (r *resolver) emailID(ctx context.Context, email string) (string, error) {
+	user, err := r.api.GetUserByEmailContext(ctx, email)
+	if err != nil {
+		return "", lookupErr(email, err)
+	}
+	return user.ID, nil
+}
+
+//userID finds a user by name: the user name first, then the display name, then the real name
+//deleted users and bots are ignored, and a name shared by several users is ambiguous
+func (r *resolver) userID(ctx context.Context, name string) (string, error) {
+	if r.users == nil {
+		users, err := getSlackUsers(ctx, r.api)
+		if err != nil {
+			return "", lookupErr("@"+name, err)
+		}
+		r.users = users
+	}
+	nameOf := []func(slack.User) string{
+		func(u slack.User) string { return u.Name },
+		func(u slack.User) string { return u.Profile.DisplayName },
+		func(u slack.User) string { return u.RealName },
+	}
+	for _, userName := range nameOf {
+		var ids []string
+		for _, u := range r.users {
+			if !u.Deleted && !u.IsBot && strings.EqualFold(userName(u), name) {
+				ids = append(ids, u.ID)
+			}
+		}
+		switch len(ids) {
+		case 0:
+			continue
+		case 1:
+			return ids[0], nil
+		}
+		return "", &recipientErr{consts.SLK_RCPT_ERR,
+			errors.New("@" + name + " is ambiguous, it is the name of the users " + strings.Join(ids, ", "))}
+	}
+	return "", &recipientErr{consts.SLK_RCPT_ERR, errors.New("no slack user named @" + name)}
+}
+
+//channelID finds a channel by name (without "#")
+func (r *resolver) channelID(ctx context.Context, name string) (string, error) {
+	if r.channels == nil {
+		channels, err := getSlackChannels(ctx, r.api)
+		if err != nil {
+			return "", lookupErr("#"+name, err)
+		}
+		r.channels = channels
+	}
+	var ids []string
+	for _, ch := range r.channels {
+		if strings.EqualFold(ch.Name, name) {
+			ids = append(ids, ch.ID)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return "", &recipientErr{consts.SLK_RCPT_ERR, errors.New("no slack channel named #" + name + " visible to the token")}
+	case 1:
+		return ids[0], nil
+	}
+	return "", &recipientErr{consts.SLK_RCPT_ERR,
+		errors.New("#" + name + " is ambiguous, it is the name of the channels " + strings.Join(ids, ", "))}
+}
+
+//idCache is the on-disk cache of the IDs resolved with one token
+type idCache struct {
+	key     string //hash of the token, the token itself is never written
+	entries map[string]cachedID
+	changed map[string]bool //recipients set or evicted since the cache was loaded, written by save
+}
+
+//cacheMu serializes the writes of the cache file by the notifiers, which are run concurrently
+var cacheMu sync.Mutex
+
+//cachedID is an ID in the cache file, with the time it was looked up
+type cachedID struct {
+	ID      string    `json:"id"`
+	Updated time.Time `json:"updated"`
+}
+
+//cachePath returns the path of the cache file
+func cachePath() string {
+	return filepath.Join(os.Getenv("HOME"), consts.SlackCacheFile)
+}
+
+//readCacheFile reads the whole cache file, keyed by token hash
+//a missing or broken cache file is an empty cache
+func readCacheFile() map[string]map[string]cachedID {
+	all := make(map[string]map[string]cachedID)
+	if data, err := ioutil.ReadFile(cachePath()); err == nil {
+		json.Unmarshal(data, &all)
+	}
+	return all
+}
+
+//loadIDCache loads the cached IDs of a token
+func loadIDCache(token string) *idCache {
+	sum := sha256.Sum256([]byte(token))
+	c := &idCache{key: hex.EncodeToString(sum[:8]), entries: readCacheFile()[hex.EncodeToString(sum[:8])], changed: make(map[string]bool)}
+	if c.entries == nil {
+		c.entries = make(map[string]cachedID)
+	}
+	return c
+}
+
+//get returns the cached ID of a recipient, unless it is older than consts.SlackCacheMaxAge
+//names are case-insensitive
+func (c *idCache) get(recipient string) (string, bool) {
+	entry, ok := c.entries[strings.ToLower(recipient)]
+	if !ok || time.Since(entry.Updated) > consts.SlackCacheMaxAge {
+		return "", false
+	}
+	return entry.ID, true
+}
+
+//set caches the ID of a recipient, the cache is written by save
+func (c *idCache) set(recipient, id string) {
+	c.entries[strings.ToLower(recipient)] = cachedID{ID: id, Updated: time.Now()}
+	c.changed[strings.ToLower(recipient)] = true
+}
+
+//evict removes the cached ID of a recipient (e.g. of a deleted channel), so that it is looked up again
+func (c *idCache) evict(recipient string) {
+	if _, ok := c.entries[strings.ToLower(recipient)]; !ok {
+		return
+	}
+	delete(c.entries, strings.ToLower(recipient))
+	c.changed[strings.ToLower(recipient)] = true
+}
+
+//save writes the cache file if IDs were resolved or evicted
+//only these changes are merged into the file as it is now, so that the IDs saved by other notifiers are kept
+//the file is replaced atomically, never left half written
+func (c *idCache) save() error {
+	if len(c.changed) == 0 {
+		return nil
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	all := readCacheFile()
+	if all[c.key] == nil {
+		all[c.key] = make(map[string]cachedID)
+	}
+	for recipient := range c.changed {
+		if entry, ok := c.entries[recipient]; ok {
+			all[c.key][recipient] = entry
+		} else {
+			delete(all[c.key], recipient)
+		}
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath()), 0700); err != nil {
+		return err
+	}
+	if err := parsers.WriteAtomic(cachePath(), data, 0600); err != nil {
+		return err
+	}
+	c.changed = make(map[string]bool)
+	return nil
+}
@@ -1,9 +1,18 @@
 package slackNotify
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"notifier/consts"
 	"notifier/parsers"
+	"notifier/registry"
 	"strings"
 
 	"github.com/nlopes/slack"
@@ -11,33 +20,52 @@ import (
 
 //parse tokens from "notifyrc.xml"
 func getToken(ntf parsers.SlackNotifier) string {
-	if ntf.Type == "slack" && ntf.State == true {
+	if strings.EqualFold(ntf.Type, consts.SlackType) && ntf.State == true {
 		return ntf.Token
 	}
 	return ""
 }
 
-//get all channels using your token
-func getSlackChannels(token string) (channels []slack.Channel, err error) {
-	api := slack.New(token)
-	channels, err = api.GetChannels(true)
-	return channels, err
+//get all channels (public and private, not archived) visible to your token with conversations.list
+//the pages of the list are fetched one after another
+func getSlackChannels(ctx context.Context, api *slack.Client) (channels []slack.Channel, err error) {
+	params := &slack.GetConversationsParameters{
+		ExcludeArchived: "true",
+		Limit:           200,
+		Types:           []string{"public_channel", "private_channel"},
+	}
+	for {
+		page, cursor, err := api.GetConversationsContext(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, page...)
+		if cursor == "" {
+			return channels, nil
+		}
+		params.Cursor = cursor
+	}
 }
 
-//get all group users using your token
-func getSlackUsers(token string) (users []slack.User, err error) {
-	api := slack.New(token)
-	users, err = api.GetUsers()
-	return users, err
+//get all group users using your token with users.list (all pages)
+func getSlackUsers(ctx context.Context, api *slack.Client) (users []slack.User, err error) {
+	return api.GetUsersContext(ctx)
 }
 
 //build a slack attachment for slack message parameter and return it
-//(to be extended......)
-func buildAttachment(title, pretext, text string) slack.Attachment {
+//colored and footed according to the settings of ntf, the text is formatted with mrkdwn
+//the posting time is shown in the footer only if there is a footer
+func buildAttachment(title, pretext, text string, ntf parsers.SlackNotifier) slack.Attachment {
 	attachment := slack.Attachment{
-		Title:   title,
-		Pretext: pretext,
-		Text:    text,
+		Color:      ntf.Color,
+		Title:      title,
+		Pretext:    pretext,
+		Text:       text,
+		MarkdownIn: []string{"text", "pretext"},
+		Footer:     ntf.Footer,
+	}
+	if ntf.Footer != "" {
+		attachment.Ts = timestamp()
 	}
 
 	return attachment
@@ -49,78 +77,251 @@ func buildMessageParameters(attachment slack.Attachment, ntf parsers.SlackNotifi
 	params.Attachments = []slack.Attachment{attachment}
 	params.AsUser = ntf.AsUser
 	params.Username = ntf.UserName
-	params.IconEmoji = ":" + ntf.IconEmoji + ":"
+	params.IconEmoji = iconEmoji(ntf)
+	params.IconURL = ntf.IconURL
+	if ntf.UnfurlLinks != nil {
+		params.UnfurlLinks = *ntf.UnfurlLinks
+	}
+	if ntf.UnfurlMedia != nil {
+		params.UnfurlMedia = *ntf.UnfurlMedia
+	}
 	return params
 }
 
+//filesPrefix marks the delivery of the files uploaded after a message, e.g. "files:#dev"
+//it is a delivery of its own, so that a failed upload is retried without posting the message again
+const filesPrefix = "files:"
+
+//upload files to a channel using files.upload, one file after another
+func uploadFiles(ctx context.Context, api *slack.Client, channelID string, files []registry.Attachment) error {
+	for _, file := range files {
+		_, err := api.UploadFileContext(ctx, slack.FileUploadParameters{
+			Reader:   bytes.NewReader(file.Data),
+			Filename: file.Name,
+			Title:    file.Name,
+			Channels: []string{channelID},
+		})
+		if err != nil {
+			return err
+		}
+		log.Println("file", file.Name, "uploaded to slack userID(channelID):", channelID)
+	}
+	return nil
+}
+
 //send message to channels using your token parsed from SlackNotifier
-func postMsgChannels(ntf parsers.SlackNotifier, channelIDs []string, msgTitle, attachTitle, attachPretext, attachText string) ([]string, string, consts.ERR) {
+//and upload the files to each channel after the message
+//the message is made of the blocks instead of the attachment if there are blocks
+//the recipients may be IDs, @names, emails or #channels (see resolver), the deliveries keep them as they are
+//the upload of the files is recorded as the delivery to filesPrefix + recipient
+//and a recipient with filesPrefix gets the files only (e.g. when the upload is retried)
+//posting continues for all channels even if some of them fail
+func postMsgChannels(ctx context.Context, ntf parsers.SlackNotifier, recipients []string, msgTitle, attachTitle, attachPretext, attachText string, blocks []Block, files []registry.Attachment) []registry.Delivery {
 	token := ntf.Token
 	if token == "" {
 		log.Println("Your slack token is invalid, please check that.")
-		return []string{}, "", consts.SLK_TOKEN_INVAL
+		return registry.NewDeliveries(recipients, consts.SLK_TOKEN_INVAL, nil)
 	}
 	api := slack.New(token)
-	msgAttachment := buildAttachment(attachTitle, attachPretext, attachText)
+	res := newResolver(api, token)
+	msgAttachment := buildAttachment(attachTitle, attachPretext, attachText, ntf)
 	params := buildMessageParameters(msgAttachment, ntf)
+	if len(blocks) > 0 {
+		params.Attachments = nil
+	}
+	if w := registry.DryRun(ctx); w != nil {
+		//a dry-run never looks up the recipients, only the cached IDs are shown
+		channels := make([]string, len(recipients))
+		for i, recipient := range recipients {
+			channels[i] = recipient
+			if id, ok := res.cache.get(recipient); ok {
+				channels[i] += " (" + id + ")"
+			} else if !isSlackID(recipient) {
+				channels[i] += " (resolved when sending)"
+			}
+		}
+		printDryRun(w, channels, msgTitle, params, blocks, files)
+		if len(files) == 0 {
+			return registry.DryRunDeliveries(recipients)
+		}
+		dryRun := make([]string, 0, 2*len(recipients))
+		for _, recipient := range recipients {
+			dryRun = append(dryRun, recipient, filesPrefix+recipient)
+		}
+		return registry.DryRunDeliveries(dryRun)
+	}
+	defer func() {
+		if err := res.cache.save(); err != nil {
+			log.Println("cannot save the slack IDs cache:", err)
+		}
+	}()
 
-	var (
-		timestamp string
-		err       error
-	)
-	for _, channelID := range channelIDs {
-		_, timestamp, err = api.PostMessage(channelID, msgTitle, params)
-		if err != nil {
-			log.Println(err)
-			//return exact ERR code using the err string info
-			if strings.Contains(err.Error(), "auth") {
+	deliveries := make([]registry.Delivery, 0, len(recipients))
+	for i, recipient := range recipients {
+		target := strings.TrimPrefix(recipient, filesPrefix)
+		channelID, err := res.resolve(ctx, target)
+		if rcptErr, ok := err.(*recipientErr); ok {
+			log.Println("cannot resolve the slack recipient", target, ":", rcptErr)
+			if rcptErr.code == consts.SLK_TOKEN_INVAL {
 				log.Println("Your slack token is invalid, please check that.")
-				return channelIDs, timestamp, consts.SLK_TOKEN_INVAL
-			} else if strings.Contains(err.Error(), "dial tcp: lookup slack.com: no such host") {
-				log.Println("You may lose Internet connection or be refused by remote host.",
-					"Try fixing your network and send again")
-				return channelIDs, timestamp, consts.SLK_SVR_CONN_ERR
-			} else if strings.Contains(err.Error(), "channel_not_found") {
-				log.Println("Try checking this slack user(or channel):", channelID, " and send again")
-				return channelIDs, timestamp, consts.SLK_CHL_ERR
+				return append(deliveries, registry.NewDeliveries(recipients[i:], consts.SLK_TOKEN_INVAL, rcptErr.err)...)
+			}
+			deliveries = append(deliveries, registry.NewDelivery(recipient, rcptErr.code, rcptErr.err))
+			continue
+		}
+		if !strings.HasPrefix(recipient, filesPrefix) {
+			err = postMessage(ctx, api, token, channelID, msgTitle, params, blocks)
+			if err == nil {
+				log.Println("slack userID(channelID): ", channelID, " posted successfully")
+				deliveries = append(deliveries, registry.NewDelivery(recipient, consts.SUCCESS, nil))
 			}
-			return channelIDs, timestamp, consts.SLK_CHL_ERR
 		}
-		log.Println("slack userID(channelID): ", channelID, " posted successfully")
+		if err == nil && len(files) > 0 {
+			recipient = filesPrefix + target
+			err = uploadFiles(ctx, api, channelID, files)
+			if err == nil {
+				deliveries = append(deliveries, registry.NewDelivery(recipient, consts.SUCCESS, nil))
+			}
+		}
+		if err == nil {
+			continue
+		}
+		log.Println(err)
+		//record exact ERR code using the err string info
+		if strings.Contains(err.Error(), "auth") {
+			//an invalid token fails all the remaining channels as well
+			log.Println("Your slack token is invalid, please check that.")
+			deliveries = append(deliveries, registry.NewDelivery(recipient, consts.SLK_TOKEN_INVAL, err))
+			return append(deliveries, registry.NewDeliveries(recipients[i+1:], consts.SLK_TOKEN_INVAL, err)...)
+		} else if rateErr, ok := err.(*slack.RateLimitedError); ok {
+			log.Println("Posting is rate limited by slack, retry after", rateErr.RetryAfter)
+			dlv := registry.NewDelivery(recipient, consts.RATE_LIMITED, err)
+			dlv.RetryAfter = rateErr.RetryAfter
+			deliveries = append(deliveries, dlv)
+		} else if _, ok := err.(net.Error); ok || strings.Contains(err.Error(), "dial tcp: lookup slack.com: no such host") {
+			log.Println("You may lose Internet connection or be refused by remote host.",
+				"Try fixing your network and send again")
+			deliveries = append(deliveries, registry.NewDelivery(recipient, consts.SLK_SVR_CONN_ERR, err))
+		} else if strings.Contains(err.Error(), "channel_not_found") || strings.Contains(err.Error(), "user_not_found") {
+			log.Println("Try checking this slack user(or channel):", recipient, " and send again")
+			//a cached ID may be the one of a deleted channel, the name is looked up again next time
+			res.cache.evict(target)
+			deliveries = append(deliveries, registry.NewDelivery(recipient, consts.SLK_CHL_ERR, err))
+		} else {
+			deliveries = append(deliveries, registry.NewDelivery(recipient, consts.SLK_CHL_ERR, err))
+		}
+	}
+
+	return deliveries
+}
+
+//postMessage posts a message to a channel with chat.postMessage
+//nlopes/slack cannot post blocks, so a message with blocks is posted as a form built from the same parameters
+//the errors are those of slack.PostMessageContext (e.g. *slack.RateLimitedError)
+func postMessage(ctx context.Context, api *slack.Client, token, channelID, text string, params slack.PostMessageParameters, blocks []Block) error {
+	if len(blocks) == 0 {
+		_, _, err := api.PostMessageContext(ctx, channelID, text, params)
+		return err
+	}
+	endpoint, values, err := slack.UnsafeApplyMsgOptions(token, channelID,
+		slack.MsgOptionText(text, params.EscapeText), slack.MsgOptionPostMessageParameters(params))
+	if err != nil {
+		return err
+	}
+	blocksJSON, err := json.Marshal(blocks)
+	if err != nil {
+		return err
+	}
+	values.Set("blocks", string(blocksJSON))
+
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &slack.RateLimitedError{RetryAfter: registry.ParseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("slack server error: " + resp.Status)
+	}
+	var slackResp slack.SlackResponse
+	if err := json.NewDecoder(resp.Body).Decode(&slackResp); err != nil {
+		return err
+	}
+	if !slackResp.Ok {
+		return errors.New(slackResp.Error)
+	}
+	return nil
+}
 
-	return channelIDs, timestamp, consts.SUCCESS
+//printDryRun prints the chat.postMessage and files.upload requests that postMsgChannels would send
+func printDryRun(w io.Writer, channels []string, msgTitle string, params slack.PostMessageParameters, blocks []Block, files []registry.Attachment) {
+	payload, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		log.Println(err)
+	}
+	if len(blocks) > 0 {
+		blocksJSON, err := json.MarshalIndent(blocks, "", "  ")
+		if err != nil {
+			log.Println(err)
+		}
+		payload = append(append(payload, "\nblocks: "...), blocksJSON...)
+	}
+	for _, channel := range channels {
+		fmt.Fprintln(w, "=== [dry-run] POST", slack.SLACK_API+"chat.postMessage", "channel:", channel)
+		fmt.Fprintln(w, "text:", msgTitle)
+		fmt.Fprintln(w, string(payload))
+		for _, file := range files {
+			fmt.Fprintln(w, "=== [dry-run] POST", slack.SLACK_API+"files.upload", "channel:", channel)
+			fmt.Fprintf(w, "filename: %s (%s, %d bytes)\n", file.Name, file.ContentType, len(file.Data))
+		}
+	}
 }
 
 //send message to users using your token parsed from SlackNotifier
-func postMsgUsers(ntf parsers.SlackNotifier, userIDs []string, msgTitle string, attachment slack.Attachment) ([]string, string, consts.ERR) {
-	return postMsgChannels(ntf, userIDs, msgTitle,
-		attachment.Title, attachment.Pretext, attachment.Text)
+func postMsgUsers(ctx context.Context, ntf parsers.SlackNotifier, userIDs []string, msgTitle string, attachment slack.Attachment, blocks []Block, files []registry.Attachment) []registry.Delivery {
+	return postMsgChannels(ctx, ntf, userIDs, msgTitle,
+		attachment.Title, attachment.Pretext, attachment.Text, blocks, files)
 }
 
-//SlackNotify (to []string, subject, msg string, ntfs Notifiers)
+//SlackNotify (ctx, to []string, subject, msg string, blocks []Block, files []Attachment, ntf SlackNotifier)
 //post a notification with subject and message provided with parameters
+//or with the Block Kit blocks (see ComposeBlocks) and the subject as the text of the notifications if there are blocks
 //to the slack userIDs(ChannelIDs) stored in(to []string)
-//ChannelID and UserID are both available
-func SlackNotify(to []string, subject, msg string, ntfs parsers.Notifiers) ([]string, string, consts.ERR) {
-	ntf := ntfs.SlackNotifier
+//ChannelID and UserID are both available, as well as @names, emails and #channels with a token (see resolver)
+//files are uploaded with the slack token, webhooks cannot upload files so they are not sent via type "slackWebhook"
+//return the delivery result of each target, or an ERR if nothing was posted
+func SlackNotify(ctx context.Context, to []string, subject, msg string, blocks []Block, files []registry.Attachment, ntf parsers.SlackNotifier) ([]registry.Delivery, consts.ERR) {
+	var deliveries []registry.Delivery
 	if ntf.State == true {
 		switch strings.ToLower(ntf.Type) {
-		case "slack":
+		case consts.SlackType:
 			if len(to) == 0 {
-				return []string{}, "", consts.SLK_NOTGT
+				return nil, consts.SLK_NOTGT
 			}
 			attachment := slack.Attachment{Text: msg}
-			return postMsgUsers(ntf, to, subject, attachment)
-		case "slackwebhook":
-			IconEmoji := ":" + ntf.IconEmoji + ":"
+			deliveries = postMsgUsers(ctx, ntf, to, subject, attachment, blocks, files)
+			return deliveries, registry.FirstErr(deliveries)
+		case strings.ToLower(consts.SlackWebhookType):
+			if len(files) > 0 {
+				log.Println("slack webhooks cannot upload files, attachments are not sent via type", consts.SlackWebhookType)
+			}
+			payload := buildPayload(subject, msg, blocks, ntf)
 			//post to all channelIDs stored in slacklistfile only when there is just one webhook url
 			if len(ntf.WebhookURLs) == 1 && len(to) > 0 {
-				return to, "",
-					postMsgWebhookWithChannels(ntf.WebhookURLs[0], to, subject, msg, ntf.UserName, IconEmoji)
+				deliveries = postMsgWebhookWithChannels(ctx, ntf.WebhookURLs[0], to, payload)
+			} else {
+				deliveries = postMsgWebhooks(ctx, ntf.WebhookURLs, nil, payload)
 			}
-			return to, "", postMsgWebhooks(ntf.WebhookURLs, subject, msg, ntf.UserName, IconEmoji)
+			return deliveries, registry.FirstErr(deliveries)
 		}
 	}
-	return []string{}, "", consts.SLK_INVAL
+	return nil, consts.SLK_INVAL
 }
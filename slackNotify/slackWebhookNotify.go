@@ -1,82 +1,141 @@
 package slackNotify
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"notifier/consts"
-	"strings"
+	"notifier/registry"
 )
 
-func buildPayload(channelID, title, text, userName, iconEmoji string) string {
-	return `{` + `"username": "` + userName +
-		`", "icon_emoji": "` + iconEmoji +
-		`", "text": "` + title +
-		`", "channel": "` + channelID +
-		`", "attachments":[{"text": "` + text +
-		`"}]` + `}`
+//webhookRecipients names the hookURLs in the deliveries, since the urls are secrets (see registry.URLRecipient)
+func webhookRecipients(hookURLs []string) []string {
+	recipients := make([]string, len(hookURLs))
+	for i, hurl := range hookURLs {
+		if len(hookURLs) == 1 {
+			recipients[i] = registry.URLRecipient(0, hurl)
+		} else {
+			recipients[i] = registry.URLRecipient(i+1, hurl)
+		}
+	}
+	return recipients
 }
 
-func postMsgWebhooks(hookURLs []string, title, text string, userName, iconEmoji string) consts.ERR {
-	for _, hurl := range hookURLs {
-		if err := postMsgWebhook(hurl, title, text, userName, iconEmoji); err != consts.NIL {
-			return err
+//postMsgWebhooks posts a message to the default channel of each hookURL named in recipients (see webhookRecipients)
+//or of all hookURLs if recipients is nil
+//posting continues for all hookURLs even if some of them fail
+func postMsgWebhooks(ctx context.Context, hookURLs []string, recipients []string, payload WebhookPayload) []registry.Delivery {
+	selected := make(map[string]bool, len(recipients))
+	for _, recipient := range recipients {
+		selected[recipient] = true
+	}
+	deliveries := make([]registry.Delivery, 0, len(hookURLs))
+	for i, recipient := range webhookRecipients(hookURLs) {
+		if recipients != nil && !selected[recipient] {
+			continue
 		}
+		dlv := postMsgWebhook(ctx, hookURLs[i], recipient, payload)
+		dlv.Recipient, dlv.Key = recipient, registry.URLKey(hookURLs[i])
+		deliveries = append(deliveries, dlv)
 	}
 	fmt.Println("(If the post is [HTTP 200 OK] but you did not receive any notification, please check the webhook urls)")
-	return consts.NIL
+	return deliveries
 }
 
 //PostMsgWebhook post a message to the default hookURL channel
-func postMsgWebhook(hookURL string, title, text string, userName, iconEmoji string) consts.ERR {
-	return postMsgWebhookWithChannel(hookURL, "", title, text, userName, iconEmoji)
+//hookName names the hookURL in the dry run and the logs
+func postMsgWebhook(ctx context.Context, hookURL, hookName string, payload WebhookPayload) registry.Delivery {
+	return postMsgWebhookWithChannel(ctx, hookURL, hookName, "", payload)
 }
 
-func postMsgWebhookWithChannels(hookURL string, channelIDs []string, title, text string, userName, iconEmoji string) consts.ERR {
+//postMsgWebhookWithChannels posts a message to each channel through the hookURL
+//posting continues for all channels even if some of them fail
+func postMsgWebhookWithChannels(ctx context.Context, hookURL string, channelIDs []string, payload WebhookPayload) []registry.Delivery {
+	deliveries := make([]registry.Delivery, 0, len(channelIDs))
 	for _, chID := range channelIDs {
-		if err := postMsgWebhookWithChannel(hookURL, chID, title, text, userName, iconEmoji); err != consts.NIL {
-			return err
-		}
+		dlv := postMsgWebhookWithChannel(ctx, hookURL, registry.URLRecipient(0, hookURL), chID, payload)
+		dlv.Key = registry.URLKey(hookURL)
+		deliveries = append(deliveries, dlv)
 	}
 	fmt.Println("(If the post is sucessfully[HTTP 200 OK] but you did not receive any notification, please check the webhook urls)")
-	return consts.NIL
+	return deliveries
 }
 
 //PostMsgWebhookWithChannel post a message to the default hookURL channel or to the channel specified by  para:"channel"
-func postMsgWebhookWithChannel(hookURL string, channelID, title, text string, userName, iconEmoji string) consts.ERR {
-	//build a complete message with attatchments
-	payload := buildPayload(channelID, title, text, userName, iconEmoji)
-	body := strings.NewReader(payload)
-	req, err := http.NewRequest("POST", hookURL, body)
+//and returns the delivery to the channel (without recipient if channelID is "", see postMsgWebhooks)
+//with the Retry-After of the response if the posting is rate limited
+//the hookURL is a secret, so the dry run and the logs name it hookName (see registry.URLRecipient)
+func postMsgWebhookWithChannel(ctx context.Context, hookURL, hookName, channelID string, payload WebhookPayload) registry.Delivery {
+	recipient := channelID
+	//marshal the complete message with its attachments
+	body, err := payload.marshal(channelID)
+	if err != nil {
+		log.Println(err)
+		return registry.NewDelivery(recipient, consts.INVALID_PAYLOAD, err)
+	}
+	if w := registry.DryRun(ctx); w != nil {
+		fmt.Fprintln(w, "=== [dry-run] POST", hookName)
+		fmt.Fprintln(w, string(body))
+		return registry.DryRunDeliveries([]string{recipient})[0]
+	}
+	req, err := http.NewRequest("POST", hookURL, bytes.NewReader(body))
 	//log.Println("req:", req)
 	if err != nil {
 		log.Println("Please check you network connection and try again.")
-		return consts.REQ_FAIL
+		return registry.NewDelivery(recipient, consts.REQ_FAIL, registry.URLError(err))
 	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
 	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		err = registry.URLError(err)
+		log.Println(hookName+":", err)
+		log.Println("Please check you network connection and try again.")
+		return registry.NewDelivery(recipient, consts.REQ_FAIL, err)
+	}
+	defer resp.Body.Close()
 	//check the response status code. (default: 200 OK)
 	//slack's incoming webhook page only provides 5 kind of error codes
 	//https://api.slack.com/changelog/2016-05-17-changes-to-errors-for-incoming-webhooks
-	switch resp.StatusCode {
-	case 400:
-		log.Println("[HTTP 400 BAD REQUEST]. The payload you sent can not be understood: " + payload)
-		return consts.INVALID_PAYLOAD
-	case 403:
-		log.Println("[HTTP 403 FORBIDDEN]. The team associated with your posting has some kind of restriction on the webhook posting in this context")
-		return consts.ACTION_FORBID
-	case 404:
-		log.Println("[HTTP 404 NOT FOUND]. Invalid Webhook or channel ID.\nPlease check the target channel \"" + channelID +
-			"\" or Webhook url: " + hookURL)
-		return consts.CHL_NOT_FOUND
-	case 410:
-		log.Println("[HTTP 410 GONE]. The channel \"" + channelID + "\" has been archived and doesn't accept further messages, even from your incoming webhook")
-		return consts.CHL_ARCHIVED
-	case 500:
-		log.Println("[HTTP 500 SERVER ERR]. Something strange and unusual happened that was likely not your fault at all.")
-		return consts.ROLLUP_ERROR
+	//any other 5xx (e.g. 502, 503, 504 from a proxy) is temporary as well, any other non-2xx is an error
+	status := "[HTTP " + resp.Status + "]. "
+	var code consts.ERR
+	switch {
+	case resp.StatusCode == 400:
+		log.Println(status + "The payload you sent can not be understood: " + string(body))
+		code = consts.INVALID_PAYLOAD
+	case resp.StatusCode == 403:
+		log.Println(status + "The team associated with your posting has some kind of restriction on the webhook posting in this context")
+		code = consts.ACTION_FORBID
+	case resp.StatusCode == 404:
+		log.Println(status + "Invalid Webhook or channel ID.\nPlease check the target channel \"" + channelID +
+			"\" or Webhook url: " + hookName)
+		code = consts.CHL_NOT_FOUND
+	case resp.StatusCode == 410:
+		log.Println(status + "The channel \"" + channelID + "\" has been archived and doesn't accept further messages, even from your incoming webhook")
+		code = consts.CHL_ARCHIVED
+	case resp.StatusCode == 429:
+		log.Println(status + "The posting is rate limited")
+		code = consts.RATE_LIMITED
+	case resp.StatusCode >= 500:
+		log.Println(status + "Something strange and unusual happened that was likely not your fault at all.")
+		code = consts.ROLLUP_ERROR
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		log.Println(status + "Unexpected response from the webhook")
+		code = consts.WHK_HTTP_ERR
+	}
+	if code != consts.NIL {
+		//the detail is the status and the error text of slack (e.g. "HTTP 404 Not Found: channel_not_found")
+		dlv := registry.NewDelivery(recipient, code, registry.HTTPError(resp))
+		dlv.RetryAfter = registry.ParseRetryAfter(resp.Header.Get("Retry-After"))
+		if dlv.RetryAfter > 0 {
+			log.Println("retry after", dlv.RetryAfter)
+		}
+		return dlv
 	}
-	resp.Body.Close()
 
-	log.Println("[HTTP 200 OK]. Message posted successfully")
-	return consts.NIL
+	log.Println(status + "Message posted successfully")
+	return registry.NewDelivery(recipient, consts.NIL, nil)
 }
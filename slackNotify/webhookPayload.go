@@ -0,0 +1,87 @@
+package slackNotify
+
+import (
+	"encoding/json"
+	"notifier/parsers"
+	"strconv"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+//WebhookPayload is the JSON payload posted to a slack incoming webhook
+//it is marshalled with encoding/json, so quotes, backslashes and newlines in messages are escaped
+//https://api.slack.com/reference/messaging/payload
+type WebhookPayload struct {
+	Text        string              `json:"text"`
+	Channel     string              `json:"channel,omitempty"`
+	Username    string              `json:"username,omitempty"`
+	IconEmoji   string              `json:"icon_emoji,omitempty"`
+	IconURL     string              `json:"icon_url,omitempty"`
+	UnfurlLinks *bool               `json:"unfurl_links,omitempty"`
+	UnfurlMedia *bool               `json:"unfurl_media,omitempty"`
+	Attachments []WebhookAttachment `json:"attachments,omitempty"`
+	Blocks      []Block             `json:"blocks,omitempty"`
+}
+
+//WebhookAttachment is an attachment of a WebhookPayload, with the settings of buildAttachment
+type WebhookAttachment struct {
+	Color      string      `json:"color,omitempty"`
+	Title      string      `json:"title,omitempty"`
+	Pretext    string      `json:"pretext,omitempty"`
+	Text       string      `json:"text,omitempty"`
+	MarkdownIn []string    `json:"mrkdwn_in,omitempty"`
+	Footer     string      `json:"footer,omitempty"`
+	Ts         json.Number `json:"ts,omitempty"`
+}
+
+//webhookAttachment converts an attachment built with buildAttachment
+func webhookAttachment(a slack.Attachment) WebhookAttachment {
+	return WebhookAttachment{
+		Color:      a.Color,
+		Title:      a.Title,
+		Pretext:    a.Pretext,
+		Text:       a.Text,
+		MarkdownIn: a.MarkdownIn,
+		Footer:     a.Footer,
+		Ts:         a.Ts,
+	}
+}
+
+//buildPayload builds the webhook payload of a notification with its title as text
+//and the message in an attachment, formatted with the settings of ntf
+//if the notification has blocks, they replace the attachment (the title is then the text of the notifications)
+func buildPayload(title, text string, blocks []Block, ntf parsers.SlackNotifier) WebhookPayload {
+	payload := WebhookPayload{
+		Text:        title,
+		Username:    ntf.UserName,
+		IconEmoji:   iconEmoji(ntf),
+		IconURL:     ntf.IconURL,
+		UnfurlLinks: ntf.UnfurlLinks,
+		UnfurlMedia: ntf.UnfurlMedia,
+		Blocks:      blocks,
+	}
+	if len(blocks) == 0 {
+		payload.Attachments = []WebhookAttachment{webhookAttachment(buildAttachment("", "", text, ntf))}
+	}
+	return payload
+}
+
+//marshal marshals the payload for a channel, or for the default channel of the webhook if channelID is empty
+func (p WebhookPayload) marshal(channelID string) ([]byte, error) {
+	p.Channel = channelID
+	return json.Marshal(p)
+}
+
+//iconEmoji returns the icon emoji of ntf as :emoji:, or "" if it is not set
+func iconEmoji(ntf parsers.SlackNotifier) string {
+	if ntf.IconEmoji == "" {
+		return ""
+	}
+	return ":" + ntf.IconEmoji + ":"
+}
+
+//timestamp returns the current time as the ts of an attachment (shown in its footer)
+func timestamp() json.Number {
+	return json.Number(strconv.FormatInt(time.Now().Unix(), 10))
+}
@@ -0,0 +1,70 @@
+package webhookNotify
+
+import (
+	"context"
+	"log"
+	"notifier/consts"
+	"notifier/parsers"
+	"notifier/registry"
+	"strings"
+)
+
+func init() {
+	registry.Register(consts.WebhookType, newWebhookNotifier)
+}
+
+//webhookNotifier is the registry.Notifier of type "webhook"
+type webhookNotifier struct {
+	name  string
+	ntf   parsers.WebhookNotifier
+	retry parsers.RetryPolicy
+}
+
+//newWebhookNotifier builds a webhookNotifier from its settings in notifyrcFile
+func newWebhookNotifier(cfg parsers.NotifierConfig) (registry.Notifier, consts.ERR) {
+	n := &webhookNotifier{name: cfg.Name, retry: cfg.Retry}
+	if err := cfg.Decode(&n.ntf); err != nil {
+		log.Println(err)
+		return nil, consts.NOTIFRC_PARSE_ERR
+	}
+	return n, consts.NIL
+}
+
+//Name returns the name of the notifier in notifyrcFile
+func (n *webhookNotifier) Name() string {
+	return n.name
+}
+
+//Validate checks the notification type "webhook", if the state is "on" and the url is set
+func (n *webhookNotifier) Validate() consts.ERR {
+	if strings.ToLower(n.ntf.Type) == consts.WebhookType && n.ntf.State && n.ntf.URL != "" {
+		return consts.NIL
+	}
+	return consts.WHK_INVAL
+}
+
+//RecipientKey returns the key of the webhook url in the current settings (see registry.RecipientKeyer)
+func (n *webhookNotifier) RecipientKey(recipient string) string {
+	return registry.URLKey(n.ntf.URL)
+}
+
+//Send posts the notification to the webhook url
+//the posting is retried according to the retry policy if it failed with a temporary error
+func (n *webhookNotifier) Send(ctx context.Context, ntf registry.Notification) registry.Result {
+	data := BodyData{
+		Subject:    ntf.Subject,
+		Message:    ntf.Message,
+		HTML:       ntf.HTML,
+		Level:      ntf.Level.String(),
+		Recipients: ntf.Recipients,
+	}
+	deliveries, err := WebhookNotify(ctx, data, n.ntf)
+	if len(deliveries) > 0 {
+		deliveries = registry.Retry(ctx, n.retry, deliveries, func([]string) []registry.Delivery {
+			retried, _ := WebhookNotify(ctx, data, n.ntf)
+			return retried
+		})
+		err = registry.FirstErr(deliveries)
+	}
+	return registry.NewResult(n.name, n.ntf.Type, deliveries, err)
+}
@@ -0,0 +1,181 @@
+package webhookNotify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"notifier/consts"
+	"notifier/parsers"
+	"notifier/registry"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//defaultBody is used when no body template is configured
+const defaultBody = `{"subject": {{json .Subject}}, "text": {{json .Message}}}`
+
+//BodyData is the data that a body template is rendered with
+//e.g. {"text": {{json .Message}}, "to": {{json .Recipients.email}}}
+type BodyData struct {
+	Subject    string
+	Message    string
+	HTML       string
+	Level      string //"" if no level was given
+	Recipients map[string][]string
+}
+
+//template functions available in a body template
+var bodyFuncs = template.FuncMap{
+	//json marshals any value into JSON, so that quotes and newlines in messages are escaped
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+}
+
+//buildBody renders the body template of a webhook with data
+func buildBody(bodyTmpl string, data BodyData) (string, consts.ERR) {
+	if bodyTmpl == "" {
+		bodyTmpl = defaultBody
+	}
+	tmpl, err := template.New("body").Funcs(bodyFuncs).Parse(bodyTmpl)
+	if err != nil {
+		log.Println(err)
+		return "", consts.WHK_TMPL_ERR
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		log.Println(err)
+		return "", consts.WHK_TMPL_ERR
+	}
+	return body.String(), consts.NIL
+}
+
+//isSuccess checks the status code against the success codes of a webhook
+//any 2xx status code is a success if no success codes are configured
+func isSuccess(statusCode int, successCodes []int) bool {
+	if len(successCodes) == 0 {
+		return statusCode >= 200 && statusCode < 300
+	}
+	for _, code := range successCodes {
+		if statusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+//statusERR maps a failed HTTP status code onto an ERR code
+//hookName names the webhook url in the logs (see registry.URLRecipient)
+func statusERR(statusCode int, hookName, payload string) consts.ERR {
+	status := "[HTTP " + strconv.Itoa(statusCode) + " " + strings.ToUpper(http.StatusText(statusCode)) + "]. "
+	switch {
+	case statusCode == 400:
+		log.Println(status + "The payload you sent can not be understood: " + payload)
+		return consts.INVALID_PAYLOAD
+	case statusCode == 403:
+		log.Println(status + "The webhook refused your posting: " + hookName)
+		return consts.ACTION_FORBID
+	case statusCode == 404:
+		log.Println(status + "Invalid webhook url: " + hookName)
+		return consts.CHL_NOT_FOUND
+	case statusCode == 410:
+		log.Println(status + "The webhook doesn't accept further messages: " + hookName)
+		return consts.CHL_ARCHIVED
+	case statusCode == 429:
+		log.Println(status + "The posting is rate limited: " + hookName)
+		return consts.RATE_LIMITED
+	case statusCode >= 500:
+		log.Println(status + "Something strange and unusual happened on the server side.")
+		return consts.ROLLUP_ERROR
+	}
+	log.Println(status + "Unexpected response from webhook: " + hookName)
+	return consts.WHK_HTTP_ERR
+}
+
+//sensitiveHeaders are masked in the dry-run output, as well as all the headers set in the config file
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+//maskHeaders returns a copy of header whose sensitive values are replaced with "******"
+func maskHeaders(header http.Header, configured map[string]string) http.Header {
+	masked := header.Clone()
+	for key := range configured {
+		if masked.Get(key) != "" {
+			masked.Set(key, "******")
+		}
+	}
+	for _, key := range sensitiveHeaders {
+		if masked.Get(key) != "" {
+			masked.Set(key, "******")
+		}
+	}
+	return masked
+}
+
+//postWebhook sends the rendered payload to the webhook url with the configured method and headers
+//and returns the delivery to the url, with the Retry-After of the response if the posting failed
+func postWebhook(ctx context.Context, ntf parsers.WebhookNotifier, payload string) registry.Delivery {
+	method := strings.ToUpper(ntf.Method)
+	if method == "" {
+		method = "POST"
+	}
+	//the url is a secret, the dry run and the logs only show its name
+	name := registry.URLRecipient(0, ntf.URL)
+	req, err := http.NewRequest(method, ntf.URL, strings.NewReader(payload))
+	if err != nil {
+		err = registry.URLError(err)
+		log.Println(name+":", err)
+		return registry.NewDelivery(name, consts.REQ_FAIL, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for key, val := range ntf.Headers {
+		req.Header.Set(key, val)
+	}
+	if w := registry.DryRun(ctx); w != nil {
+		fmt.Fprintln(w, "=== [dry-run]", method, name)
+		maskHeaders(req.Header, ntf.Headers).Write(w)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, payload)
+		return registry.DryRunDeliveries([]string{name})[0]
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		err = registry.URLError(err)
+		log.Println(name+":", err)
+		log.Println("Please check you network connection and try again.")
+		return registry.NewDelivery(name, consts.REQ_FAIL, err)
+	}
+	defer resp.Body.Close()
+
+	if !isSuccess(resp.StatusCode, ntf.SuccessCodes) {
+		dlv := registry.NewDelivery(name, statusERR(resp.StatusCode, name, payload), registry.HTTPError(resp))
+		dlv.RetryAfter = registry.ParseRetryAfter(resp.Header.Get("Retry-After"))
+		return dlv
+	}
+	log.Println("[HTTP " + resp.Status + "]. Message posted successfully to " + name)
+	return registry.NewDelivery(name, consts.NIL, nil)
+}
+
+//WebhookNotify (ctx, data BodyData, ntf WebhookNotifier)
+//render the body template of the webhook with data
+//and send it to the webhook url
+//return the delivery result of the url, or an ERR if nothing was sent
+func WebhookNotify(ctx context.Context, data BodyData, ntf parsers.WebhookNotifier) ([]registry.Delivery, consts.ERR) {
+	if !(strings.ToLower(ntf.Type) == consts.WebhookType && ntf.State) || ntf.URL == "" {
+		return nil, consts.WHK_INVAL
+	}
+	payload, err := buildBody(ntf.Body, data)
+	if err != consts.NIL {
+		return nil, err
+	}
+	dlv := postWebhook(ctx, ntf, payload)
+	dlv.Key = registry.URLKey(ntf.URL)
+	return []registry.Delivery{dlv}, dlv.Err
+}